@@ -0,0 +1,253 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AdminAPI serves the admin API of the prewarm command below /admin/. All
+// requests must carry the header "Authorization: Bearer <Token>".
+//
+//	GET    /admin/credentials                                    list all credentials and their status
+//	POST   /admin/credentials                                    add a credential ({"userid":"...","accesskey":"..."})
+//	GET    /admin/credentials/{userid}/{accesskey}               show the status of a single credential
+//	DELETE /admin/credentials/{userid}/{accesskey}               remove a credential that was added with POST
+//	POST   /admin/credentials/{userid}/{accesskey}/prewarm       prewarm a single credential immediately
+//	GET    /admin/credentials/{userid}/{accesskey}/compare       compare the credential in Keystone and Memcache
+//	POST   /admin/prewarm                                        prewarm all credentials immediately
+type AdminAPI struct {
+	Prewarmer *Prewarmer
+	Token     string
+	// Credentials that shall be prewarmed immediately are sent into this
+	// channel. It should be buffered, since requests are rejected when the
+	// channel is full.
+	Trigger chan<- []CredentialID
+}
+
+// adminCredentialStatus is the representation of a credential in the admin API.
+type adminCredentialStatus struct {
+	UserID        string     `json:"userid"`
+	AccessKey     string     `json:"accesskey"`
+	Sources       []string   `json:"sources"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	LastFailureAt *time.Time `json:"last_failure_at"`
+	LastError     *string    `json:"last_error"`
+	Stale         bool       `json:"stale"`
+	NextRefreshAt *time.Time `json:"next_refresh_at"`
+}
+
+// adminComparison is the response of the compare endpoint.
+type adminComparison struct {
+	UserID      string              `json:"userid"`
+	AccessKey   string              `json:"accesskey"`
+	Keystone    adminComparisonSide `json:"keystone"`
+	Memcache    adminComparisonSide `json:"memcache"`
+	Equal       bool                `json:"equal"`
+	Differences []PayloadDifference `json:"differences"`
+}
+
+type adminComparisonSide struct {
+	Found bool    `json:"found"`
+	Error *string `json:"error"`
+}
+
+// AddTo registers the endpoints in the given mux.
+func (a AdminAPI) AddTo(mux *http.ServeMux) {
+	handle := func(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+		mux.HandleFunc(pattern, a.requireToken(handler))
+	}
+	handle("GET /admin/credentials", a.listCredentials)
+	handle("POST /admin/credentials", a.addCredential)
+	handle("GET /admin/credentials/{userid}/{accesskey}", a.showCredential)
+	handle("DELETE /admin/credentials/{userid}/{accesskey}", a.removeCredential)
+	handle("POST /admin/credentials/{userid}/{accesskey}/prewarm", a.prewarmCredential)
+	handle("GET /admin/credentials/{userid}/{accesskey}/compare", a.compareCredential)
+	handle("POST /admin/prewarm", a.prewarmAll)
+}
+
+func (a AdminAPI) requireToken(handler func(http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondWithAdminError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (a AdminAPI) listCredentials(w http.ResponseWriter, r *http.Request) {
+	result := []adminCredentialStatus{}
+	for _, cred := range a.Prewarmer.Credentials() {
+		status, ok := a.statusOf(cred)
+		if ok {
+			result = append(result, status)
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, result)
+}
+
+func (a AdminAPI) showCredential(w http.ResponseWriter, r *http.Request) {
+	status, ok := a.statusOf(credentialFromPath(r))
+	if !ok {
+		respondWithAdminError(w, http.StatusNotFound, "credential is not being prewarmed")
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, status)
+}
+
+func (a AdminAPI) statusOf(cred CredentialID) (adminCredentialStatus, bool) {
+	status, exists := a.Prewarmer.Status(cred)
+	if !exists {
+		return adminCredentialStatus{}, false
+	}
+	result := adminCredentialStatus{
+		UserID:        cred.UserID,
+		AccessKey:     cred.AccessKey,
+		Sources:       a.Prewarmer.CredentialSources(cred),
+		LastSuccessAt: timeOrNil(status.LastSuccessAt),
+		LastFailureAt: timeOrNil(status.LastFailureAt),
+		Stale:         status.Stale,
+		NextRefreshAt: timeOrNil(status.NextRefreshAt),
+	}
+	if status.LastError != nil {
+		msg := status.LastError.Error()
+		result.LastError = &msg
+	}
+	return result, true
+}
+
+func (a AdminAPI) addCredential(w http.ResponseWriter, r *http.Request) {
+	var data struct {
+		UserID    string `json:"userid"`
+		AccessKey string `json:"accesskey"`
+	}
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&data)
+	if err != nil {
+		respondWithAdminError(w, http.StatusBadRequest, "cannot parse request body: "+err.Error())
+		return
+	}
+	cred := CredentialID{UserID: strings.TrimSpace(data.UserID), AccessKey: strings.TrimSpace(data.AccessKey)}
+	if cred.UserID == "" || cred.AccessKey == "" {
+		respondWithAdminError(w, http.StatusBadRequest, "userid and accesskey must not be empty")
+		return
+	}
+
+	if !a.Prewarmer.AddRuntimeCredential(cred) {
+		respondWithAdminError(w, http.StatusConflict, "credential was already added through the admin API")
+		return
+	}
+	a.trigger([]CredentialID{cred}) // if this fails, the credential will be prewarmed in the next cycle
+	status, _ := a.statusOf(cred)
+	writeJSONResponse(w, http.StatusCreated, status)
+}
+
+func (a AdminAPI) removeCredential(w http.ResponseWriter, r *http.Request) {
+	cred := credentialFromPath(r)
+	if a.Prewarmer.RemoveRuntimeCredential(cred) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if _, exists := a.Prewarmer.Status(cred); exists {
+		respondWithAdminError(w, http.StatusConflict, "credential was not added through the admin API, so it can only be removed from the config file, credentials file or discovery selectors")
+		return
+	}
+	respondWithAdminError(w, http.StatusNotFound, "credential is not being prewarmed")
+}
+
+func (a AdminAPI) prewarmCredential(w http.ResponseWriter, r *http.Request) {
+	cred := credentialFromPath(r)
+	if _, exists := a.Prewarmer.Status(cred); !exists {
+		respondWithAdminError(w, http.StatusNotFound, "credential is not being prewarmed")
+		return
+	}
+	a.respondToTrigger(w, []CredentialID{cred})
+}
+
+func (a AdminAPI) prewarmAll(w http.ResponseWriter, r *http.Request) {
+	a.respondToTrigger(w, a.Prewarmer.Credentials())
+}
+
+func (a AdminAPI) respondToTrigger(w http.ResponseWriter, creds []CredentialID) {
+	if !a.trigger(creds) {
+		respondWithAdminError(w, http.StatusServiceUnavailable, "too many prewarms are queued already, try again later")
+		return
+	}
+	writeJSONResponse(w, http.StatusAccepted, map[string]int{"queued": len(creds)})
+}
+
+func (a AdminAPI) trigger(creds []CredentialID) bool {
+	select {
+	case a.Trigger <- creds:
+		return true
+	default:
+		return false
+	}
+}
+
+func (a AdminAPI) compareCredential(w http.ResponseWriter, r *http.Request) {
+	cred := credentialFromPath(r)
+	result := adminComparison{
+		UserID:      cred.UserID,
+		AccessKey:   cred.AccessKey,
+		Differences: []PayloadDifference{},
+	}
+
+	fromKeystone, err := GetCredentialFromKeystone(r.Context(), a.Prewarmer.Keystone, cred)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		msg := err.Error()
+		result.Keystone.Error = &msg
+	}
+	result.Keystone.Found = fromKeystone != nil
+	fromMemcache, err := GetCredentialFromMemcache(a.Prewarmer.Memcache, cred)
+	if err != nil {
+		msg := err.Error()
+		result.Memcache.Error = &msg
+	}
+	result.Memcache.Found = fromMemcache != nil
+
+	if result.Keystone.Error == nil && result.Memcache.Error == nil {
+		result.Equal = fromKeystone.EqualTo(fromMemcache)
+		if fromKeystone != nil && fromMemcache != nil {
+			result.Differences = DiffCredentialPayloads(*fromKeystone, *fromMemcache)
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, result)
+}
+
+func credentialFromPath(r *http.Request) CredentialID {
+	return CredentialID{UserID: r.PathValue("userid"), AccessKey: r.PathValue("accesskey")}
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func respondWithAdminError(w http.ResponseWriter, status int, msg string) {
+	writeJSONResponse(w, status, map[string]string{"error": msg})
+}
+
+func writeJSONResponse(w http.ResponseWriter, status int, data any) {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot encode response: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(buf, '\n')) //nolint:errcheck // nothing we can do about it
+}
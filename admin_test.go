@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPI(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	p, fk, _ := newTestPrewarmer(t, cred1)
+	fk.Credentials[cred2] = "secret"
+	triggered := make(chan []CredentialID, 1)
+	mux := http.NewServeMux()
+	AdminAPI{Prewarmer: p, Token: "s3cr3t", Trigger: triggered}.AddTo(mux)
+
+	request := func(method, path, token, body string, expectedStatus int) map[string]any {
+		t.Helper()
+		var reqBody io.Reader = http.NoBody
+		if body != "" {
+			reqBody = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, path, reqBody)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != expectedStatus {
+			t.Errorf("expected %s %s to return %d, but got %d: %s", method, path, expectedStatus, rec.Code, rec.Body.String())
+		}
+		if rec.Code == http.StatusNoContent || strings.HasPrefix(rec.Body.String(), "[") {
+			return nil
+		}
+		var result map[string]any
+		err := json.Unmarshal(rec.Body.Bytes(), &result)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		return result
+	}
+	expectTriggered := func(expected ...CredentialID) {
+		t.Helper()
+		select {
+		case creds := <-triggered:
+			if !reflect.DeepEqual(creds, expected) {
+				t.Errorf("expected prewarm of %v to be triggered, but got %v", expected, creds)
+			}
+		default:
+			t.Errorf("expected prewarm of %v to be triggered, but nothing was triggered", expected)
+		}
+	}
+
+	// all requests require the token
+	request("GET", "/admin/credentials", "", "", http.StatusUnauthorized)
+	request("GET", "/admin/credentials", "wrong", "", http.StatusUnauthorized)
+
+	// show status of credentials
+	status := request("GET", "/admin/credentials/uid1/key1", "s3cr3t", "", http.StatusOK)
+	if status["last_success_at"] != nil {
+		t.Errorf("expected no last success before first prewarm, but got %v", status["last_success_at"])
+	}
+	p.doPrewarmCycle(t.Context(), 0)
+	status = request("GET", "/admin/credentials/uid1/key1", "s3cr3t", "", http.StatusOK)
+	if status["last_success_at"] == nil {
+		t.Error("expected last success after first prewarm")
+	}
+	if !reflect.DeepEqual(status["sources"], []any{"config"}) {
+		t.Errorf("expected sources = [config], but got %v", status["sources"])
+	}
+	request("GET", "/admin/credentials/uid2/key2", "s3cr3t", "", http.StatusNotFound)
+
+	// add a credential at runtime
+	request("POST", "/admin/credentials", "s3cr3t", `{"userid":"uid2","accesskey":"key2"}`, http.StatusCreated)
+	expectTriggered(cred2)
+	request("POST", "/admin/credentials", "s3cr3t", `{"userid":"uid2","accesskey":"key2"}`, http.StatusConflict)
+	request("POST", "/admin/credentials", "s3cr3t", `{"userid":"uid2"}`, http.StatusBadRequest)
+	if creds := p.Credentials(); !reflect.DeepEqual(creds, []CredentialID{cred1, cred2}) {
+		t.Errorf("expected credentials to be %v, but got %v", []CredentialID{cred1, cred2}, creds)
+	}
+
+	// trigger prewarms (when the queue is full, requests are rejected)
+	request("POST", "/admin/prewarm", "s3cr3t", "", http.StatusAccepted)
+	request("POST", "/admin/credentials/uid1/key1/prewarm", "s3cr3t", "", http.StatusServiceUnavailable)
+	expectTriggered(cred1, cred2)
+	request("POST", "/admin/credentials/uid1/key1/prewarm", "s3cr3t", "", http.StatusAccepted)
+	expectTriggered(cred1)
+	request("POST", "/admin/credentials/uid3/key3/prewarm", "s3cr3t", "", http.StatusNotFound)
+
+	// only credentials added at runtime can be removed at runtime
+	request("DELETE", "/admin/credentials/uid1/key1", "s3cr3t", "", http.StatusConflict)
+	request("DELETE", "/admin/credentials/uid2/key2", "s3cr3t", "", http.StatusNoContent)
+	request("DELETE", "/admin/credentials/uid2/key2", "s3cr3t", "", http.StatusNotFound)
+	if creds := p.Credentials(); !reflect.DeepEqual(creds, []CredentialID{cred1}) {
+		t.Errorf("expected credentials to be %v, but got %v", []CredentialID{cred1}, creds)
+	}
+
+	// compare Keystone and Memcache
+	result := request("GET", "/admin/credentials/uid1/key1/compare", "s3cr3t", "", http.StatusOK)
+	if result["equal"] != true {
+		t.Errorf("expected credential to be equal in Keystone and Memcache, but got %v", result)
+	}
+	fk.Credentials[cred1] = "newsecret"
+	result = request("GET", "/admin/credentials/uid1/key1/compare", "s3cr3t", "", http.StatusOK)
+	expectedDiffs := []any{map[string]any{"field": "Secret", "keystone": "<redacted>", "memcache": "<redacted>"}}
+	if result["equal"] != false || !reflect.DeepEqual(result["differences"], expectedDiffs) {
+		t.Errorf("expected secret to differ between Keystone and Memcache, but got %v", result)
+	}
+	result = request("GET", "/admin/credentials/uid2/key2/compare", "s3cr3t", "", http.StatusOK)
+	expectedSide := map[string]any{"found": false, "error": nil}
+	if result["equal"] != false || !reflect.DeepEqual(result["memcache"], expectedSide) {
+		t.Errorf("expected credential to be missing in Memcache, but got %v", result)
+	}
+}
@@ -0,0 +1,376 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.yaml.in/yaml/v3"
+)
+
+// Config is the structure of the configuration file for the prewarm command
+// (given with --config). Since JSON is a subset of YAML, the file may be
+// written in either format.
+//
+// All fields are optional. Options given as CLI flags take precedence over
+// the respective options in the file.
+type Config struct {
+	SwiftConfigPaths       []string
+	MemcacheServers        []string
+	MemcacheDNSInterval    time.Duration
+	MemcacheHealthInterval *time.Duration
+	MemcacheTLS            MemcacheTLSConfig
+	MemcacheConnectTimeout time.Duration
+	MemcacheIOTimeout      time.Duration
+	MemcacheTries          int
+	MemcacheReplicas       int
+	ListenAddress          string
+	ReadyMinFreshRatio     *float64
+	AdminTokenFile         string
+	Expiry                 time.Duration
+	Conservative           bool
+	Evict                  bool
+	MaxStaleness           time.Duration
+	Keystone               KeystoneConfig
+	Concurrency            int
+	RequestTimeout         time.Duration
+	Discovery              DiscoveryConfig
+	Credentials            []CredentialConfig
+}
+
+// DiscoveryConfig appears in type Config.
+type DiscoveryConfig struct {
+	Selectors []DiscoverySelector
+	Interval  time.Duration
+}
+
+// KeystoneConfig appears in type Config. Fields where zero is a meaningful
+// value are pointers, so that they can be left unset.
+type KeystoneConfig struct {
+	RateLimit                  *float64
+	Burst                      int
+	BreakerThreshold           *int
+	BreakerBackoff             time.Duration
+	SecretRevalidationInterval *time.Duration
+}
+
+// CredentialConfig contains the configuration for a single prewarmed
+// credential. The zero values of Expiry and Conservative mean that the global
+// settings apply.
+type CredentialConfig struct {
+	ID           CredentialID
+	Expiry       time.Duration
+	Conservative *bool
+	// Labels are free-form key-value pairs that describe this credential,
+	// e.g. {"customer": "Example Corp"}.
+	Labels map[string]string
+}
+
+// LoadConfig reads and validates the configuration file at the given path.
+func LoadConfig(path string) (*Config, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseConfig(buf)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration in %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func parseConfig(buf []byte) (*Config, error) {
+	// this is the structure of the file as it is written (all validation and
+	// conversion into type Config happens below)
+	var data struct {
+		SwiftConfigPaths       []string       `yaml:"swift_config"`
+		MemcacheServers        []string       `yaml:"memcache_servers"`
+		MemcacheDNSInterval    time.Duration  `yaml:"memcache_dns_interval"`
+		MemcacheHealthInterval *time.Duration `yaml:"memcache_health_interval"`
+		MemcacheTLS            struct {
+			Enabled    bool   `yaml:"enabled"`
+			CAFile     string `yaml:"ca_file"`
+			CertFile   string `yaml:"cert_file"`
+			KeyFile    string `yaml:"key_file"`
+			ServerName string `yaml:"server_name"`
+		} `yaml:"memcache_tls"`
+		MemcacheConnectTimeout time.Duration `yaml:"memcache_connect_timeout"`
+		MemcacheIOTimeout      time.Duration `yaml:"memcache_io_timeout"`
+		MemcacheTries          int           `yaml:"memcache_tries"`
+		MemcacheReplicas       int           `yaml:"memcache_replicas"`
+		ListenAddress          string        `yaml:"listen"`
+		ReadyMinFreshRatio     *float64      `yaml:"ready_min_fresh_ratio"`
+		AdminTokenFile         string        `yaml:"admin_token_file"`
+		Expiry                 time.Duration `yaml:"expiry"`
+		Conservative           bool          `yaml:"conservative"`
+		Evict                  bool          `yaml:"evict"`
+		MaxStaleness           time.Duration `yaml:"max_staleness"`
+		Keystone               struct {
+			RateLimit                  *float64       `yaml:"rate_limit"`
+			Burst                      int            `yaml:"burst"`
+			BreakerThreshold           *int           `yaml:"breaker_threshold"`
+			BreakerBackoff             time.Duration  `yaml:"breaker_backoff"`
+			SecretRevalidationInterval *time.Duration `yaml:"secret_revalidation_interval"`
+		} `yaml:"keystone"`
+		Concurrency    int           `yaml:"concurrency"`
+		RequestTimeout time.Duration `yaml:"request_timeout"`
+		Discovery      struct {
+			Selectors []string      `yaml:"selectors"`
+			Interval  time.Duration `yaml:"interval"`
+		} `yaml:"discovery"`
+		Credentials []struct {
+			Credential   string            `yaml:"credential"`
+			Expiry       time.Duration     `yaml:"expiry"`
+			Conservative *bool             `yaml:"conservative"`
+			Labels       map[string]string `yaml:"labels"`
+		} `yaml:"credentials"`
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(buf))
+	dec.KnownFields(true)
+	err := dec.Decode(&data)
+	if err != nil && !errors.Is(err, io.EOF) { // io.EOF means that the file is empty
+		return nil, err
+	}
+
+	var errs []error
+	cfg := Config{
+		SwiftConfigPaths:       data.SwiftConfigPaths,
+		MemcacheServers:        data.MemcacheServers,
+		MemcacheDNSInterval:    data.MemcacheDNSInterval,
+		MemcacheHealthInterval: data.MemcacheHealthInterval,
+		MemcacheTLS:            MemcacheTLSConfig(data.MemcacheTLS),
+		MemcacheConnectTimeout: data.MemcacheConnectTimeout,
+		MemcacheIOTimeout:      data.MemcacheIOTimeout,
+		MemcacheTries:          data.MemcacheTries,
+		MemcacheReplicas:       data.MemcacheReplicas,
+		ListenAddress:          data.ListenAddress,
+		ReadyMinFreshRatio:     data.ReadyMinFreshRatio,
+		AdminTokenFile:         data.AdminTokenFile,
+		Expiry:                 data.Expiry,
+		Conservative:           data.Conservative,
+		Evict:                  data.Evict,
+		MaxStaleness:           data.MaxStaleness,
+		Keystone:               KeystoneConfig(data.Keystone),
+		Concurrency:            data.Concurrency,
+		RequestTimeout:         data.RequestTimeout,
+	}
+	for idx, server := range data.MemcacheServers {
+		err := validateMemcacheServerSpec(server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("memcache_servers[%d]: %w", idx, err))
+		}
+	}
+	if err := cfg.MemcacheTLS.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("memcache_tls: %w", err))
+	}
+	if data.MemcacheDNSInterval < 0 {
+		errs = append(errs, errors.New("memcache_dns_interval: must not be negative"))
+	}
+	if data.MemcacheHealthInterval != nil && *data.MemcacheHealthInterval < 0 {
+		errs = append(errs, errors.New("memcache_health_interval: must not be negative"))
+	}
+	if data.MemcacheConnectTimeout < 0 {
+		errs = append(errs, errors.New("memcache_connect_timeout: must not be negative"))
+	}
+	if data.MemcacheIOTimeout < 0 {
+		errs = append(errs, errors.New("memcache_io_timeout: must not be negative"))
+	}
+	if data.MemcacheTries < 0 {
+		errs = append(errs, errors.New("memcache_tries: must not be negative"))
+	}
+	if data.MemcacheReplicas < 0 {
+		errs = append(errs, errors.New("memcache_replicas: must not be negative"))
+	}
+	if data.Expiry != 0 {
+		if err := validateExpiry(data.Expiry); err != nil {
+			errs = append(errs, fmt.Errorf("expiry: %w", err))
+		}
+	}
+	if len(data.Discovery.Selectors) > 0 {
+		cfg.Discovery.Selectors, err = ParseDiscoverySelectors(data.Discovery.Selectors)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("discovery.selectors: %w", err))
+		}
+	}
+	if data.MaxStaleness < 0 {
+		errs = append(errs, errors.New("max_staleness: must not be negative"))
+	}
+	if data.ReadyMinFreshRatio != nil && (*data.ReadyMinFreshRatio < 0 || *data.ReadyMinFreshRatio > 1) {
+		errs = append(errs, errors.New("ready_min_fresh_ratio: must be between 0 and 1"))
+	}
+	if data.Keystone.RateLimit != nil && *data.Keystone.RateLimit < 0 {
+		errs = append(errs, errors.New("keystone.rate_limit: must not be negative"))
+	}
+	if data.Keystone.Burst < 0 {
+		errs = append(errs, errors.New("keystone.burst: must not be negative"))
+	}
+	if data.Keystone.BreakerThreshold != nil && *data.Keystone.BreakerThreshold < 0 {
+		errs = append(errs, errors.New("keystone.breaker_threshold: must not be negative"))
+	}
+	if data.Keystone.BreakerBackoff < 0 {
+		errs = append(errs, errors.New("keystone.breaker_backoff: must not be negative"))
+	}
+	if data.Keystone.SecretRevalidationInterval != nil && *data.Keystone.SecretRevalidationInterval < 0 {
+		errs = append(errs, errors.New("keystone.secret_revalidation_interval: must not be negative"))
+	}
+	if data.Concurrency < 0 {
+		errs = append(errs, errors.New("concurrency: must not be negative"))
+	}
+	if data.RequestTimeout < 0 {
+		errs = append(errs, errors.New("request_timeout: must not be negative"))
+	}
+	cfg.Discovery.Interval = data.Discovery.Interval
+	if data.Discovery.Interval < 0 {
+		errs = append(errs, errors.New("discovery.interval: must not be negative"))
+	}
+
+	seen := make(map[CredentialID]bool)
+	for idx, entry := range data.Credentials {
+		ids, err := ParseCredentials([]string{entry.Credential})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("credentials[%d].credential: %w", idx, err))
+			continue
+		}
+		if seen[ids[0]] {
+			errs = append(errs, fmt.Errorf("credentials[%d].credential: duplicate entry for %q", idx, ids[0].String()))
+		}
+		seen[ids[0]] = true
+		if entry.Expiry != 0 {
+			if err := validateExpiry(entry.Expiry); err != nil {
+				errs = append(errs, fmt.Errorf("credentials[%d].expiry: %w", idx, err))
+			}
+		}
+		for key := range entry.Labels {
+			if strings.TrimSpace(key) == "" {
+				errs = append(errs, fmt.Errorf("credentials[%d].labels: label names may not be empty", idx))
+			}
+		}
+		cfg.Credentials = append(cfg.Credentials, CredentialConfig{
+			ID:           ids[0],
+			Expiry:       entry.Expiry,
+			Conservative: entry.Conservative,
+			Labels:       entry.Labels,
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &cfg, nil
+}
+
+func validateExpiry(expiry time.Duration) error {
+	switch {
+	case expiry < time.Second:
+		return fmt.Errorf("must be at least 1s, but is %s", expiry)
+	case expiry > 30*24*time.Hour:
+		// memcached would interpret larger values as absolute UNIX timestamps
+		return fmt.Errorf("must be at most 720h (30 days), but is %s", expiry)
+	case expiry%time.Second != 0:
+		return fmt.Errorf("must be a whole number of seconds, but is %s", expiry)
+	default:
+		return nil
+	}
+}
+
+// ApplyConfigToFlags fills all flags of the prewarm command that were not
+// given on the command line with the values from the config file.
+func ApplyConfigToFlags(cmd *cobra.Command, cfg Config) {
+	isUnset := func(flagName string) bool { return !cmd.Flags().Changed(flagName) }
+
+	if len(cfg.MemcacheServers) > 0 && isUnset("servers") {
+		flagMemcacheServers = cfg.MemcacheServers
+	}
+	if cfg.MemcacheDNSInterval != 0 && isUnset("memcache-dns-interval") {
+		flagMemcacheDNSInterval = cfg.MemcacheDNSInterval
+	}
+	if cfg.MemcacheHealthInterval != nil && isUnset("memcache-health-interval") {
+		flagMemcacheHealthInterval = *cfg.MemcacheHealthInterval
+	}
+	if cfg.MemcacheTLS.Enabled && isUnset("memcache-tls") {
+		flagMemcache.TLS.Enabled = true
+	}
+	if cfg.MemcacheTLS.CAFile != "" && isUnset("memcache-tls-ca-file") {
+		flagMemcache.TLS.CAFile = cfg.MemcacheTLS.CAFile
+	}
+	if cfg.MemcacheTLS.CertFile != "" && isUnset("memcache-tls-cert-file") {
+		flagMemcache.TLS.CertFile = cfg.MemcacheTLS.CertFile
+	}
+	if cfg.MemcacheTLS.KeyFile != "" && isUnset("memcache-tls-key-file") {
+		flagMemcache.TLS.KeyFile = cfg.MemcacheTLS.KeyFile
+	}
+	if cfg.MemcacheTLS.ServerName != "" && isUnset("memcache-tls-server-name") {
+		flagMemcache.TLS.ServerName = cfg.MemcacheTLS.ServerName
+	}
+	if cfg.MemcacheConnectTimeout != 0 && isUnset("memcache-connect-timeout") {
+		flagMemcache.ConnectTimeout = cfg.MemcacheConnectTimeout
+	}
+	if cfg.MemcacheIOTimeout != 0 && isUnset("memcache-io-timeout") {
+		flagMemcache.IOTimeout = cfg.MemcacheIOTimeout
+	}
+	if cfg.MemcacheTries != 0 && isUnset("memcache-tries") {
+		flagMemcache.Tries = cfg.MemcacheTries
+	}
+	if cfg.MemcacheReplicas != 0 && isUnset("memcache-replicas") {
+		flagMemcache.Replicas = cfg.MemcacheReplicas
+	}
+	if cfg.ListenAddress != "" && isUnset("listen") {
+		flagPromListenAddress = cfg.ListenAddress
+	}
+	if cfg.ReadyMinFreshRatio != nil && isUnset("ready-min-fresh-ratio") {
+		flagReadyMinFreshRatio = *cfg.ReadyMinFreshRatio
+	}
+	if cfg.AdminTokenFile != "" && isUnset("admin-token-file") {
+		flagAdminTokenFile = cfg.AdminTokenFile
+	}
+	if cfg.Expiry != 0 && isUnset("expiry") {
+		flagExpiryTime = cfg.Expiry
+	}
+	if cfg.Conservative && isUnset("conservative") {
+		flagConservative = cfg.Conservative
+	}
+	if cfg.Evict && isUnset("evict") {
+		flagEvict = cfg.Evict
+	}
+	if cfg.MaxStaleness != 0 && isUnset("max-staleness") {
+		flagMaxStaleness = cfg.MaxStaleness
+	}
+	if cfg.Keystone.RateLimit != nil && isUnset("keystone-rate-limit") {
+		flagKeystoneRateLimit = *cfg.Keystone.RateLimit
+	}
+	if cfg.Keystone.Burst != 0 && isUnset("keystone-burst") {
+		flagKeystoneBurst = cfg.Keystone.Burst
+	}
+	if cfg.Keystone.BreakerThreshold != nil && isUnset("keystone-breaker-threshold") {
+		flagKeystoneBreakerThreshold = *cfg.Keystone.BreakerThreshold
+	}
+	if cfg.Keystone.BreakerBackoff != 0 && isUnset("keystone-breaker-backoff") {
+		flagKeystoneBreakerBackoff = cfg.Keystone.BreakerBackoff
+	}
+	if cfg.Keystone.SecretRevalidationInterval != nil && isUnset("keystone-secret-revalidation-interval") {
+		flagKeystoneSecretRevalidationInterval = *cfg.Keystone.SecretRevalidationInterval
+	}
+	if cfg.Concurrency != 0 && isUnset("concurrency") {
+		flagConcurrency = cfg.Concurrency
+	}
+	if cfg.RequestTimeout != 0 && isUnset("request-timeout") {
+		flagRequestTimeout = cfg.RequestTimeout
+	}
+	if len(cfg.Discovery.Selectors) > 0 && isUnset("discover") {
+		flagDiscoverySelectors = make([]string, len(cfg.Discovery.Selectors))
+		for idx, selector := range cfg.Discovery.Selectors {
+			flagDiscoverySelectors[idx] = selector.String()
+		}
+	}
+	if cfg.Discovery.Interval != 0 && isUnset("discovery-interval") {
+		flagDiscoveryInterval = cfg.Discovery.Interval
+	}
+}
@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	yes := true
+	expected := &Config{
+		MemcacheServers: []string{"memcached-0:11211", "memcached-1:11211"},
+		ListenAddress:   ":9090",
+		Expiry:          20 * time.Minute,
+		Conservative:    false,
+		Discovery: DiscoveryConfig{
+			Selectors: []DiscoverySelector{{Kind: "project-tag", Value: "s3-prewarm"}},
+			Interval:  5 * time.Minute,
+		},
+		Credentials: []CredentialConfig{
+			{ID: CredentialID{UserID: "uid1", AccessKey: "key1"}},
+			{
+				ID:           CredentialID{UserID: "uid2", AccessKey: "key2"},
+				Expiry:       time.Hour,
+				Conservative: &yes,
+				Labels:       map[string]string{"customer": "Example Corp"},
+			},
+		},
+	}
+
+	// JSON is a subset of YAML, so both formats are accepted
+	inputs := map[string]string{
+		"YAML": `
+			memcache_servers: [ memcached-0:11211, memcached-1:11211 ]
+			listen: ":9090"
+			expiry: 20m
+			discovery:
+				selectors: [ "project-tag:s3-prewarm" ]
+				interval: 5m
+			credentials:
+				- credential: uid1:key1
+				- credential: uid2:key2
+					expiry: 1h
+					conservative: true
+					labels:
+						customer: Example Corp
+		`,
+		"JSON": `{
+			"memcache_servers": ["memcached-0:11211", "memcached-1:11211"],
+			"listen": ":9090",
+			"expiry": "20m",
+			"discovery": { "selectors": ["project-tag:s3-prewarm"], "interval": "5m" },
+			"credentials": [
+				{ "credential": "uid1:key1" },
+				{ "credential": "uid2:key2", "expiry": "1h", "conservative": true, "labels": { "customer": "Example Corp" } }
+			]
+		}`,
+	}
+	for format, input := range inputs {
+		// YAML does not allow tabs for indentation
+		input = strings.ReplaceAll(input, "\t", "  ")
+		cfg, err := parseConfig([]byte(input))
+		if err != nil {
+			t.Errorf("unexpected error while parsing %s config: %s", format, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(cfg, expected) {
+			t.Errorf("expected %s config to parse into %#v, but got %#v", format, expected, cfg)
+		}
+	}
+
+	// an empty file is valid (all options can be given on the command line)
+	cfg, err := parseConfig(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(cfg, &Config{}) {
+		t.Errorf("expected empty config to parse into the zero value, but got %#v", cfg)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	testCases := map[string][]string{
+		`unknown_option: 42`:                         {"field unknown_option not found"},
+		`memcache_servers: [ "memcached-0:foo" ]`:    {"memcache_servers[0]: "},
+		`memcache_tls: { ca_file: ca.pem }`:          {"memcache_tls: TLS options for memcached are given, but TLS is not enabled"},
+		`expiry: 500ms`:                              {"expiry: must be at least 1s"},
+		`expiry: 1000h`:                              {"expiry: must be at most 720h"},
+		`discovery: { selectors: [ "tenant:foo" ] }`: {"discovery.selectors: cannot parse discovery selector"},
+		`credentials: [ { credential: "foo" } ]`:     {"credentials[0].credential: "},
+		`credentials: [ { credential: "uid:key" }, { credential: "uid:key" } ]`: {`credentials[1].credential: duplicate entry for "uid:key"`},
+		// all errors are reported at once
+		`{ expiry: 1.5s, credentials: [ { credential: "uid:key", expiry: 0.5s, labels: { "": "foo" } } ] }`: {
+			"expiry: must be a whole number of seconds",
+			"credentials[0].expiry: must be at least 1s",
+			"credentials[0].labels: label names may not be empty",
+		},
+	}
+
+	for input, expectedMessages := range testCases {
+		_, err := parseConfig([]byte(input))
+		if err == nil {
+			t.Errorf("expected error while parsing %q, but got none", input)
+			continue
+		}
+		for _, msg := range expectedMessages {
+			if !strings.Contains(err.Error(), msg) {
+				t.Errorf("expected error while parsing %q to contain %q, but got %q", input, msg, err.Error())
+			}
+		}
+	}
+}
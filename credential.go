@@ -9,7 +9,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
+	"os"
 	"reflect"
+	"slices"
 	"sort"
 	"strings"
 
@@ -46,22 +48,52 @@ func (cred CredentialID) AsLabels() prometheus.Labels {
 
 // MustParseCredentials parses CredentialIDs passed in as CLI arguments.
 func MustParseCredentials(args []string) []CredentialID {
+	result, err := ParseCredentials(args)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	return result
+}
+
+// ParseCredentials is like MustParseCredentials, but returns an error instead of failing fatally.
+func ParseCredentials(args []string) ([]CredentialID, error) {
 	result := make([]CredentialID, len(args))
 	for idx, arg := range args {
 		fields := strings.Split(arg, ":")
 		if len(fields) != 2 {
-			logg.Fatal("cannot parse userid:accesskey pair: %q", arg)
+			return nil, fmt.Errorf("cannot parse userid:accesskey pair: %q", arg)
 		}
 		cred := CredentialID{
 			UserID:    strings.TrimSpace(fields[0]),
 			AccessKey: strings.TrimSpace(fields[1]),
 		}
 		if cred.UserID == "" || cred.AccessKey == "" {
-			logg.Fatal("cannot parse userid:accesskey pair: %q", arg)
+			return nil, fmt.Errorf("cannot parse userid:accesskey pair: %q", arg)
 		}
 		result[idx] = cred
 	}
-	return result
+	return result, nil
+}
+
+// LoadCredentialsFile reads a file containing one userid:accesskey pair per
+// line. Empty lines and lines starting with "#" are ignored.
+func LoadCredentialsFile(path string) ([]CredentialID, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for line := range strings.Lines(string(buf)) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			args = append(args, line)
+		}
+	}
+	result, err := ParseCredentials(args)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", path, err)
+	}
+	return result, nil
 }
 
 // CredentialPayload contains the payload for a credential which we write into memcached.
@@ -132,6 +164,63 @@ func (p *CredentialPayload) EqualTo(other *CredentialPayload) bool {
 	return reflect.DeepEqual(p, rhs)
 }
 
+// PayloadDifference describes a field in which the payload of a credential in
+// Keystone differs from its payload in Memcache.
+type PayloadDifference struct {
+	// e.g. "Headers.X-Roles", "Project.Domain.Name" or "Secret"
+	Field string `json:"field"`
+	// the values of this field (empty if the field is absent; always redacted for "Secret")
+	Keystone string `json:"keystone"`
+	Memcache string `json:"memcache"`
+}
+
+// redactedValue replaces the values of secrets in a PayloadDifference.
+const redactedValue = "<redacted>"
+
+// DiffCredentialPayloads lists the fields in which the given payloads differ.
+// The result is empty if and only if EqualTo() considers the payloads to be
+// equal (except for the distinction between nil and empty Headers maps).
+func DiffCredentialPayloads(fromKeystone, fromMemcache CredentialPayload) []PayloadDifference {
+	var result []PayloadDifference
+	compare := func(field, keystoneValue, memcacheValue string) {
+		if keystoneValue != memcacheValue {
+			result = append(result, PayloadDifference{field, keystoneValue, memcacheValue})
+		}
+	}
+
+	headerNames := slices.Collect(maps.Keys(fromKeystone.Headers))
+	for name := range fromMemcache.Headers {
+		if _, exists := fromKeystone.Headers[name]; !exists {
+			headerNames = append(headerNames, name)
+		}
+	}
+	slices.Sort(headerNames)
+	for _, name := range headerNames {
+		keystoneValue, keystoneHas := fromKeystone.Headers[name]
+		memcacheValue, memcacheHas := fromMemcache.Headers[name]
+		switch {
+		case keystoneHas != memcacheHas:
+			// this includes the case where the header is present with an empty value on one side only
+			result = append(result, PayloadDifference{"Headers." + name, keystoneValue, memcacheValue})
+		case name == "X-Roles" && keystoneValue != "" && memcacheValue != "" &&
+			sortCommaSeparatedLikeInReference(memcacheValue, keystoneValue) == keystoneValue:
+			// like in EqualTo(), the order of roles does not matter
+			continue
+		default:
+			compare("Headers."+name, keystoneValue, memcacheValue)
+		}
+	}
+
+	compare("Project.ID", fromKeystone.Project.ID, fromMemcache.Project.ID)
+	compare("Project.Name", fromKeystone.Project.Name, fromMemcache.Project.Name)
+	compare("Project.Domain.ID", fromKeystone.Project.Domain.ID, fromMemcache.Project.Domain.ID)
+	compare("Project.Domain.Name", fromKeystone.Project.Domain.Name, fromMemcache.Project.Domain.Name)
+	if fromKeystone.Secret != fromMemcache.Secret {
+		result = append(result, PayloadDifference{"Secret", redactedValue, redactedValue})
+	}
+	return result
+}
+
 func sortCommaSeparatedLikeInReference(input, reference string) string {
 	refFieldIndex := make(map[string]int)
 	for idx, field := range strings.Split(reference, ",") {
@@ -3,7 +3,10 @@
 
 package main
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestSortCommaSeparated(t *testing.T) {
 	testCases := [][]string{
@@ -45,3 +48,33 @@ func TestSortCommaSeparated(t *testing.T) {
 		}
 	}
 }
+
+func TestDiffCredentialPayloads(t *testing.T) {
+	payload := func(roles, projectName, secret string) CredentialPayload {
+		var p CredentialPayload
+		p.Headers = map[string]string{"X-Roles": roles, "X-User-Id": "uid1"}
+		p.Project.ID = "project1"
+		p.Project.Name = projectName
+		p.Secret = secret
+		return p
+	}
+
+	// the order of roles does not matter (like in EqualTo())
+	diffs := DiffCredentialPayloads(payload("admin,member", "Project 1", "secret"), payload("member,admin", "Project 1", "secret"))
+	if len(diffs) > 0 {
+		t.Errorf("expected no differences, but got %#v", diffs)
+	}
+
+	fromMemcache := payload("member", "Project 2", "other")
+	delete(fromMemcache.Headers, "X-User-Id")
+	diffs = DiffCredentialPayloads(payload("admin,member", "Project 1", "secret"), fromMemcache)
+	expected := []PayloadDifference{
+		{"Headers.X-Roles", "admin,member", "member"},
+		{"Headers.X-User-Id", "uid1", ""},
+		{"Project.Name", "Project 1", "Project 2"},
+		{"Secret", redactedValue, redactedValue},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, diffs)
+	}
+}
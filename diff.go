@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CredentialDiff is the result of comparing a credential in Keystone with
+// its cache entry in Memcache, as reported by the `diff` command.
+type CredentialDiff struct {
+	UserID    string `json:"userid"`
+	AccessKey string `json:"accesskey"`
+	// one of the diffResult... constants
+	Result string `json:"result"`
+	// explains why the credential is missing in Keystone, or why the cache
+	// entry could not be decoded
+	Message string `json:"message,omitempty"`
+	// only set if Result is diffResultDifferent and the cache entry could be decoded
+	Differences []PayloadDifference `json:"differences,omitempty"`
+}
+
+// Values for CredentialDiff.Result.
+const (
+	diffResultEqual             = "equal"
+	diffResultDifferent         = "different"
+	diffResultMissingInCache    = "missing-in-cache"
+	diffResultMissingInKeystone = "missing-in-keystone"
+)
+
+// Exit codes of the `diff` command. When multiple credentials are compared,
+// the highest exit code among all credentials is used. (Exit code 1 is used
+// for errors that prevented the comparison, e.g. Keystone or Memcache being
+// unreachable.)
+var diffExitCodes = map[string]int{
+	diffResultEqual:             0,
+	diffResultDifferent:         2,
+	diffResultMissingInCache:    3,
+	diffResultMissingInKeystone: 4,
+}
+
+// DiffCredential compares the given credential in Keystone and Memcache.
+// Like in the check-keystone command, a credential that cannot be used to
+// login or to obtain a token counts as missing in Keystone.
+func DiffCredential(ctx context.Context, kc *KeystoneClient, mc MemcacheClient, cred CredentialID) (CredentialDiff, error) {
+	result := CredentialDiff{UserID: cred.UserID, AccessKey: cred.AccessKey}
+
+	fromKeystone, err := GetCredentialFromKeystone(ctx, kc, cred)
+	switch {
+	case errors.Is(err, ErrNotFound), errors.Is(err, ErrUnauthorized), errors.Is(err, ErrForbidden):
+		result.Result = diffResultMissingInKeystone
+		result.Message = err.Error()
+		return result, nil
+	case err != nil:
+		return result, err
+	}
+
+	fromMemcache, err := GetCredentialFromMemcache(mc, cred)
+	switch {
+	case errors.Is(err, ErrDecode):
+		// a corrupted cache entry is certainly not equal to what Keystone says
+		result.Result = diffResultDifferent
+		result.Message = err.Error()
+		return result, nil
+	case err != nil:
+		return result, err
+	case fromMemcache == nil:
+		result.Result = diffResultMissingInCache
+	case fromKeystone.EqualTo(fromMemcache):
+		result.Result = diffResultEqual
+	default:
+		result.Result = diffResultDifferent
+		result.Differences = DiffCredentialPayloads(*fromKeystone, *fromMemcache)
+	}
+	return result, nil
+}
+
+// ExitCodeForDiffs returns the exit code of the `diff` command for the given results.
+func ExitCodeForDiffs(diffs []CredentialDiff) int {
+	exitCode := 0
+	for _, diff := range diffs {
+		exitCode = max(exitCode, diffExitCodes[diff.Result])
+	}
+	return exitCode
+}
+
+// PrintDiffs renders the output of the `diff` command in human-readable form.
+func PrintDiffs(w io.Writer, diffs []CredentialDiff) {
+	for _, diff := range diffs {
+		cred := CredentialID{UserID: diff.UserID, AccessKey: diff.AccessKey}
+		fmt.Fprintf(w, "%s: %s\n", cred.String(), diff.Result)
+		if diff.Message != "" {
+			fmt.Fprintf(w, "  %s\n", diff.Message)
+		}
+		for _, d := range diff.Differences {
+			fmt.Fprintf(w, "  %s:\n    keystone: %q\n    memcache: %q\n", d.Field, d.Keystone, d.Memcache)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDiffCredential(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	cred3 := CredentialID{UserID: "uid3", AccessKey: "key3"}
+	cred4 := CredentialID{UserID: "uid4", AccessKey: "key4"}
+	p, fk, fm := newTestPrewarmer(t, cred1, cred2, cred4)
+	p.doPrewarmCycle(t.Context(), 0)
+	fk.Credentials[cred2] = "newsecret"
+	fk.Credentials[cred3] = "secret"
+	delete(fk.Credentials, cred4)
+
+	var diffs []CredentialDiff
+	for _, cred := range []CredentialID{cred1, cred2, cred3, cred4} {
+		diff, err := DiffCredential(t.Context(), p.Keystone, p.Memcache, cred)
+		expectSuccess(t, err)
+		diffs = append(diffs, diff)
+	}
+	expectedResults := []string{diffResultEqual, diffResultDifferent, diffResultMissingInCache, diffResultMissingInKeystone}
+	for idx, diff := range diffs {
+		if diff.Result != expectedResults[idx] {
+			t.Errorf("expected result %q, but got %#v", expectedResults[idx], diff)
+		}
+	}
+	if len(diffs[1].Differences) != 1 || diffs[1].Differences[0].Field != "Secret" {
+		t.Errorf("expected only the secret to differ, but got %#v", diffs[1].Differences)
+	}
+
+	// the exit code reflects the most severe result
+	for count, expectedExitCode := range []int{0, 0, 2, 3, 4} {
+		exitCode := ExitCodeForDiffs(diffs[:count])
+		if exitCode != expectedExitCode {
+			t.Errorf("expected exit code %d for %d results, but got %d", expectedExitCode, count, exitCode)
+		}
+	}
+
+	// the secret is never printed
+	var buf strings.Builder
+	PrintDiffs(&buf, diffs)
+	output := buf.String()
+	if strings.Contains(output, "newsecret") {
+		t.Errorf("expected secret to be redacted, but got: %s", output)
+	}
+	for _, expected := range []string{"uid1:key1: equal\n", "uid2:key2: different\n  Secret:\n", "uid3:key3: missing-in-cache\n", "uid4:key4: missing-in-keystone\n"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("expected output to contain %q, but got: %s", expected, output)
+		}
+	}
+
+	// a corrupted cache entry counts as different
+	fm.Set(cred1.CacheKey(), []byte("garbage"))
+	diff, err := DiffCredential(t.Context(), p.Keystone, p.Memcache, cred1)
+	expectSuccess(t, err)
+	if diff.Result != diffResultDifferent || diff.Message == "" {
+		t.Errorf("expected corrupted cache entry to be reported as different, but got %#v", diff)
+	}
+
+	// transient errors prevent the comparison
+	fk.LookupStatus = http.StatusServiceUnavailable
+	_, err = DiffCredential(t.Context(), p.Keystone, p.Memcache, cred1)
+	if !errors.Is(err, ErrTransient) {
+		t.Errorf("expected transient error, but got %v", err)
+	}
+}
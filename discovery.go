@@ -0,0 +1,288 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/domains"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/ec2credentials"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/projects"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/roles"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/users"
+	"github.com/sapcc/go-bits/logg"
+)
+
+// DiscoverySelector selects a set of Keystone users whose EC2 credentials
+// shall be prewarmed. It is given on the command line as "<kind>:<value>",
+// e.g. "user:myuser" or "project-tag:s3-prewarm".
+type DiscoverySelector struct {
+	// Kind is one of "user", "project", "domain" or "project-tag".
+	Kind string
+	// Value is an ID or name (or a tag if Kind is "project-tag").
+	Value string
+}
+
+// String returns the string representation that was parsed by ParseDiscoverySelectors.
+func (s DiscoverySelector) String() string {
+	return s.Kind + ":" + s.Value
+}
+
+var discoverySelectorKinds = []string{"user", "project", "domain", "project-tag"}
+
+// ParseDiscoverySelectors parses DiscoverySelectors passed in as CLI arguments.
+func ParseDiscoverySelectors(args []string) ([]DiscoverySelector, error) {
+	result := make([]DiscoverySelector, len(args))
+	for idx, arg := range args {
+		kind, value, ok := strings.Cut(arg, ":")
+		value = strings.TrimSpace(value)
+		if !ok || value == "" || !slices.Contains(discoverySelectorKinds, kind) {
+			return nil, fmt.Errorf(`cannot parse discovery selector %q: expected "<kind>:<id-or-name>" with kind being one of %s`,
+				arg, strings.Join(discoverySelectorKinds, ", "))
+		}
+		result[idx] = DiscoverySelector{Kind: kind, Value: value}
+	}
+	return result, nil
+}
+
+// DiscoverCredentials lists the EC2 credentials selected by the given selectors.
+//
+// For "user" selectors, all EC2 credentials of that user are selected. For the
+// other kinds of selectors, EC2 credentials are selected if they are scoped to
+// one of the selected projects and belong to a user that has a role
+// assignment (directly or through a group) in that project.
+func DiscoverCredentials(ctx context.Context, identityV3 *gophercloud.ServiceClient, selectors []DiscoverySelector) ([]CredentialID, error) {
+	var (
+		allProjectsOfUser = make(map[string]bool)            // key = user ID
+		projectsOfUser    = make(map[string]map[string]bool) // key = user ID, value = set of project IDs
+	)
+
+	for _, selector := range selectors {
+		var projectIDs []string
+		switch selector.Kind {
+		case "user":
+			userID, err := findUserID(ctx, identityV3, selector.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve discovery selector %q: %w", selector.String(), err)
+			}
+			allProjectsOfUser[userID] = true
+			continue
+		case "project":
+			projectID, err := findProjectID(ctx, identityV3, selector.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve discovery selector %q: %w", selector.String(), err)
+			}
+			projectIDs = []string{projectID}
+		case "domain":
+			domainID, err := findDomainID(ctx, identityV3, selector.Value)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve discovery selector %q: %w", selector.String(), err)
+			}
+			projectIDs, err = listProjectIDs(ctx, identityV3, projects.ListOpts{DomainID: domainID})
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve discovery selector %q: %w", selector.String(), err)
+			}
+		case "project-tag":
+			var err error
+			projectIDs, err = listProjectIDs(ctx, identityV3, projects.ListOpts{Tags: selector.Value})
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve discovery selector %q: %w", selector.String(), err)
+			}
+		}
+
+		for _, projectID := range projectIDs {
+			userIDs, err := listUserIDsInProject(ctx, identityV3, projectID)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve discovery selector %q: %w", selector.String(), err)
+			}
+			for _, userID := range userIDs {
+				if projectsOfUser[userID] == nil {
+					projectsOfUser[userID] = make(map[string]bool)
+				}
+				projectsOfUser[userID][projectID] = true
+			}
+		}
+	}
+
+	// list EC2 credentials for all relevant users
+	userIDs := make(map[string]bool)
+	for userID := range allProjectsOfUser {
+		userIDs[userID] = true
+	}
+	for userID := range projectsOfUser {
+		userIDs[userID] = true
+	}
+	var result []CredentialID
+	for userID := range userIDs {
+		page, err := ec2credentials.List(identityV3, userID).AllPages(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list EC2 credentials for user %q: %w", userID, err)
+		}
+		ec2Creds, err := ec2credentials.ExtractCredentials(page)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list EC2 credentials for user %q: %w", userID, err)
+		}
+		for _, ec2Cred := range ec2Creds {
+			if allProjectsOfUser[userID] || projectsOfUser[userID][ec2Cred.TenantID] {
+				result = append(result, CredentialID{UserID: userID, AccessKey: ec2Cred.Access})
+			}
+		}
+	}
+
+	slices.SortFunc(result, func(lhs, rhs CredentialID) int {
+		return strings.Compare(lhs.String(), rhs.String())
+	})
+	return result, nil
+}
+
+// runDiscoveryLoop repeats credential discovery in regular intervals, and
+// updates the set of prewarmed credentials accordingly.
+func runDiscoveryLoop(ctx context.Context, p *Prewarmer, selectors []DiscoverySelector, interval time.Duration) {
+	tick := time.Tick(interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			discoveredCreds, err := DiscoverCredentials(ctx, p.Keystone.IdentityV3, selectors)
+			if err != nil {
+				// keep prewarming the credentials that we know about
+				logg.Error("credential discovery failed: %s", err.Error())
+				continue
+			}
+			added, removed := p.SetDiscoveredCredentials(discoveredCreds)
+			if len(added) > 0 || len(removed) > 0 {
+				logg.Info("credential discovery found %d new and %d removed credentials", len(added), len(removed))
+			}
+		}
+	}
+}
+
+// mergeCredentials returns the union of both lists, without duplicates.
+func mergeCredentials(lhs, rhs []CredentialID) []CredentialID {
+	result := slices.Clone(lhs)
+	for _, cred := range rhs {
+		if !slices.Contains(result, cred) {
+			result = append(result, cred)
+		}
+	}
+	return result
+}
+
+func findUserID(ctx context.Context, identityV3 *gophercloud.ServiceClient, idOrName string) (string, error) {
+	user, err := users.Get(ctx, identityV3, idOrName).Extract()
+	if err == nil {
+		return user.ID, nil
+	}
+	if !gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		return "", err
+	}
+
+	page, err := users.List(identityV3, users.ListOpts{Name: idOrName}).AllPages(ctx)
+	if err != nil {
+		return "", err
+	}
+	matches, err := users.ExtractUsers(page)
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no user found with ID or name %q", idOrName)
+	case 1:
+		return matches[0].ID, nil
+	default:
+		return "", fmt.Errorf("multiple users found with name %q (use the user ID instead)", idOrName)
+	}
+}
+
+func findProjectID(ctx context.Context, identityV3 *gophercloud.ServiceClient, idOrName string) (string, error) {
+	project, err := projects.Get(ctx, identityV3, idOrName).Extract()
+	if err == nil {
+		return project.ID, nil
+	}
+	if !gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		return "", err
+	}
+
+	matches, err := listProjectIDs(ctx, identityV3, projects.ListOpts{Name: idOrName})
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no project found with ID or name %q", idOrName)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("multiple projects found with name %q (use the project ID instead)", idOrName)
+	}
+}
+
+func findDomainID(ctx context.Context, identityV3 *gophercloud.ServiceClient, idOrName string) (string, error) {
+	domain, err := domains.Get(ctx, identityV3, idOrName).Extract()
+	if err == nil {
+		return domain.ID, nil
+	}
+	if !gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
+		return "", err
+	}
+
+	page, err := domains.List(identityV3, domains.ListOpts{Name: idOrName}).AllPages(ctx)
+	if err != nil {
+		return "", err
+	}
+	matches, err := domains.ExtractDomains(page)
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no domain found with ID or name %q", idOrName)
+	}
+	return matches[0].ID, nil // domain names are unique
+}
+
+func listProjectIDs(ctx context.Context, identityV3 *gophercloud.ServiceClient, opts projects.ListOpts) ([]string, error) {
+	page, err := projects.List(identityV3, opts).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := projects.ExtractProjects(page)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(matches))
+	for idx, project := range matches {
+		result[idx] = project.ID
+	}
+	return result, nil
+}
+
+func listUserIDsInProject(ctx context.Context, identityV3 *gophercloud.ServiceClient, projectID string) ([]string, error) {
+	effective := true
+	page, err := roles.ListAssignments(identityV3, roles.ListAssignmentsOpts{
+		ScopeProjectID: projectID,
+		Effective:      &effective, // resolves group memberships into individual users
+	}).AllPages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := roles.ExtractRoleAssignments(page)
+	if err != nil {
+		return nil, err
+	}
+	var result []string
+	for _, assignment := range assignments {
+		if assignment.User.ID != "" && !slices.Contains(result, assignment.User.ID) {
+			result = append(result, assignment.User.ID)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiscoverCredentials(t *testing.T) {
+	fk, identityV3 := newFakeKeystone(t)
+	fk.Users["uid1"] = "alice"
+	fk.Users["uid2"] = "bob"
+	fk.Users["uid3"] = "carol"
+	fk.Domains["did1"] = "default"
+	fk.Domains["did2"] = "other"
+	fk.Projects = []fakeProject{
+		{ID: "pid1", Name: "first", DomainID: "did1", Tags: []string{"s3-prewarm"}},
+		{ID: "pid2", Name: "second", DomainID: "did2"},
+	}
+	fk.RoleAssignments["pid1"] = []string{"uid2", "uid3"}
+	fk.RoleAssignments["pid2"] = []string{"uid3"}
+
+	alice1 := CredentialID{UserID: "uid1", AccessKey: "alice1"}
+	bob1 := CredentialID{UserID: "uid2", AccessKey: "bob1"}
+	carol1 := CredentialID{UserID: "uid3", AccessKey: "carol1"}
+	carol2 := CredentialID{UserID: "uid3", AccessKey: "carol2"}
+	for _, cred := range []CredentialID{alice1, bob1, carol1, carol2} {
+		fk.Credentials[cred] = "secret"
+	}
+	fk.CredentialProjects[bob1] = "pid1"
+	fk.CredentialProjects[carol1] = "pid1"
+	fk.CredentialProjects[carol2] = "pid2"
+
+	testCases := []struct {
+		Selectors []string
+		Expected  []CredentialID
+	}{
+		// users are selected by ID or name, and all of their credentials are selected
+		{[]string{"user:uid1"}, []CredentialID{alice1}},
+		{[]string{"user:carol"}, []CredentialID{carol1, carol2}},
+		// projects select only those credentials that are scoped to them
+		{[]string{"project:pid1"}, []CredentialID{bob1, carol1}},
+		{[]string{"project:second"}, []CredentialID{carol2}},
+		{[]string{"project-tag:s3-prewarm"}, []CredentialID{bob1, carol1}},
+		// domains are selected by ID or name, and select the credentials scoped to any of their projects
+		{[]string{"domain:did1"}, []CredentialID{bob1, carol1}},
+		{[]string{"domain:other"}, []CredentialID{carol2}},
+		// multiple selectors are merged
+		{[]string{"user:alice", "project:second"}, []CredentialID{alice1, carol2}},
+	}
+
+	for _, tc := range testCases {
+		selectors, err := ParseDiscoverySelectors(tc.Selectors)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		actual, err := DiscoverCredentials(t.Context(), identityV3, selectors)
+		if err != nil {
+			t.Errorf("unexpected error for selectors %v: %s", tc.Selectors, err.Error())
+			continue
+		}
+		if !slices.Equal(actual, tc.Expected) {
+			t.Errorf("expected selectors %v to discover %v, but got %v", tc.Selectors, tc.Expected, actual)
+		}
+	}
+
+	// unknown users, projects and domains are an error
+	for _, selector := range []string{"user:dave", "project:third", "domain:third"} {
+		selectors, err := ParseDiscoverySelectors([]string{selector})
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		_, err = DiscoverCredentials(t.Context(), identityV3, selectors)
+		if err == nil {
+			t.Errorf("expected discovery with selector %q to fail, but it succeeded", selector)
+		}
+	}
+
+	// malformed selectors are rejected
+	for _, selector := range []string{"uid1", "group:foo", "user:"} {
+		_, err := ParseDiscoverySelectors([]string{selector})
+		if err == nil {
+			t.Errorf("expected selector %q to be rejected, but it was accepted", selector)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// CredentialPlan describes what prewarming a credential would do, as
+// reported by `prewarm --dry-run`.
+type CredentialPlan struct {
+	UserID    string `json:"userid"`
+	AccessKey string `json:"accesskey"`
+	// one of the planAction... constants
+	Action string `json:"action"`
+	// only set if Action is planActionError, or to explain another action
+	Message string `json:"message,omitempty"`
+	// only set if Action is planActionRewriteChanged or planActionSkipConflict
+	Differences []PayloadDifference `json:"differences,omitempty"`
+}
+
+// Values for CredentialPlan.Action.
+const (
+	// the cache entry does not exist and would be created
+	planActionCreate = "create"
+	// the cache entry is unchanged and its expiration time would be updated
+	planActionRefresh = "refresh"
+	// the cache entry differs from Keystone (or is corrupted) and would be overwritten
+	planActionRewriteChanged = "rewrite-changed"
+	// the cache entry differs from Keystone, but would be left alone because of --conservative
+	planActionSkipConflict = "skip-conflict"
+	// the credential was revoked in Keystone and its cache entry would be deleted because of --evict
+	planActionEvict = "evict"
+	// the prewarm would fail (see CredentialPlan.Message)
+	planActionError = "error"
+)
+
+// Plan determines what prewarming each credential would do, by performing
+// the same lookups in Keystone and reads from Memcache as a prewarm cycle,
+// but without writing into Memcache.
+func (p *Prewarmer) Plan(ctx context.Context) []CredentialPlan {
+	creds := p.Credentials()
+	result := make([]CredentialPlan, len(creds))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for range max(p.Concurrency, 1) {
+		wg.Go(func() {
+			for idx := range queue {
+				result[idx] = p.planCredentialWithTimeout(ctx, creds[idx])
+			}
+		})
+	}
+	for idx := range creds {
+		queue <- idx
+	}
+	close(queue)
+	wg.Wait()
+	return result
+}
+
+func (p *Prewarmer) planCredentialWithTimeout(ctx context.Context, cred CredentialID) CredentialPlan {
+	// like in prewarmCredentialAndRecord()
+	if p.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.RequestTimeout)
+		defer cancel()
+	}
+	plan := CredentialPlan{UserID: cred.UserID, AccessKey: cred.AccessKey}
+	var err error
+	plan.Action, plan.Differences, err = p.planCredential(ctx, cred)
+	if err != nil {
+		plan.Message = err.Error()
+	}
+	return plan
+}
+
+// planCredential follows the same logic as prewarmCredential() and
+// refresh() or tryRefreshConservatively(). If an error is returned, it is
+// either the reason for planActionError, or an explanation for the action.
+func (p *Prewarmer) planCredential(ctx context.Context, cred CredentialID) (action string, diffs []PayloadDifference, err error) {
+	_, conservative := p.settingsFor(cred)
+
+	payload, err := GetCredentialFromKeystone(ctx, p.Keystone, cred)
+	if err != nil {
+		if !p.Evict || conservative || !(errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnauthorized)) {
+			// with --conservative, only cache entries that this process wrote
+			// itself would be evicted, so a fresh process would not evict anything
+			return planActionError, nil, err
+		}
+		cached, memcacheErr := GetCredentialFromMemcache(p.Memcache, cred)
+		switch {
+		case memcacheErr != nil && !errors.Is(memcacheErr, ErrDecode):
+			return planActionError, nil, errors.Join(err, memcacheErr)
+		case cached == nil && memcacheErr == nil:
+			// nothing to evict
+			return planActionError, nil, err
+		default:
+			return planActionEvict, nil, err
+		}
+	}
+
+	cached, err := GetCredentialFromMemcache(p.Memcache, cred)
+	switch {
+	case errors.Is(err, ErrDecode) && !conservative:
+		return planActionRewriteChanged, nil, err
+	case err != nil:
+		return planActionError, nil, err
+	case cached == nil:
+		return planActionCreate, nil, nil
+	case cached.EqualTo(payload):
+		return planActionRefresh, nil, nil
+	case conservative:
+		return planActionSkipConflict, DiffCredentialPayloads(*payload, *cached), errConservativeConflict
+	default:
+		return planActionRewriteChanged, DiffCredentialPayloads(*payload, *cached), nil
+	}
+}
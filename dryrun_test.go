@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	p, fk, fm := newTestPrewarmer(t, cred1, cred2)
+	otherPayload := []byte(`[{"X-User-Id":"uid2"},{"id":"project2"},"other-secret"]`)
+
+	expectPlan := func(expectedActions ...string) []CredentialPlan {
+		t.Helper()
+		plans := p.Plan(t.Context())
+		var actions []string
+		for _, plan := range plans {
+			actions = append(actions, plan.Action)
+		}
+		if !reflect.DeepEqual(actions, expectedActions) {
+			t.Errorf("expected actions %v, but got %#v", expectedActions, plans)
+		}
+		return plans
+	}
+
+	// nothing is cached yet
+	expectPlan(planActionCreate, planActionCreate)
+	if _, exists := fm.Get(cred1.CacheKey()); exists {
+		t.Error("expected dry run to not write into Memcache")
+	}
+
+	// one entry is up to date, the other one has changed
+	p.doPrewarmCycle(t.Context(), 0)
+	fm.Set(cred2.CacheKey(), otherPayload)
+	plans := expectPlan(planActionRefresh, planActionRewriteChanged)
+	if len(plans[1].Differences) == 0 {
+		t.Errorf("expected differences for %s, but got %#v", cred2, plans[1])
+	}
+	p.Conservative = true
+	expectPlan(planActionRefresh, planActionSkipConflict)
+	p.Conservative = false
+	if item, _ := fm.Get(cred2.CacheKey()); !bytes.Equal(item.Value, otherPayload) {
+		t.Errorf("expected dry run to not write into Memcache, but got %q", item.Value)
+	}
+
+	// a corrupted entry would be overwritten
+	fm.Set(cred2.CacheKey(), []byte("garbage"))
+	expectPlan(planActionRefresh, planActionRewriteChanged)
+
+	// revoked credentials are only evicted with --evict
+	delete(fk.Credentials, cred2)
+	expectPlan(planActionRefresh, planActionError)
+	p.Evict = true
+	expectPlan(planActionRefresh, planActionEvict)
+	if _, exists := fm.Get(cred2.CacheKey()); !exists {
+		t.Error("expected dry run to not evict from Memcache")
+	}
+
+	// transient errors are reported as such
+	fk.LookupStatus = http.StatusServiceUnavailable
+	plans = expectPlan(planActionError, planActionError)
+	if plans[0].Message == "" {
+		t.Errorf("expected error message for %s, but got %#v", cred1, plans[0])
+	}
+}
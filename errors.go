@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+// Categories of errors returned by GetCredentialFromKeystone(),
+// GetCredentialFromMemcache() and SetCredentialInMemcache(). Use errors.Is()
+// to check whether a CredentialError belongs to one of these categories.
+var (
+	// ErrNotFound is returned when the credential does not exist in Keystone.
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when Keystone rejects a login with the credential.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden is returned when Keystone accepts the credential, but
+	// refuses to issue a token for its project.
+	ErrForbidden = errors.New("forbidden")
+	// ErrTransient is returned for errors that may go away on their own,
+	// e.g. server errors, timeouts or unreachable servers.
+	ErrTransient = errors.New("transient failure")
+	// ErrDecode is returned when a response or cache entry could not be decoded.
+	ErrDecode = errors.New("decode failure")
+)
+
+// errConservativeConflict is returned by Prewarmer.prewarmCredential() when
+// the --conservative flag stops it from overwriting a cache entry.
+var errConservativeConflict = errors.New("payload in Memcache does not match our expectation")
+
+// CredentialError is the error type returned by functions that operate on a
+// single credential.
+type CredentialError struct {
+	Credential CredentialID
+	// Action is a short description of what failed, e.g. "lookup EC2 credential in Keystone".
+	Action string
+	// Category is one of the Err... variables above, or nil if the error does
+	// not fit into any category.
+	Category error
+	Inner    error
+	// Hint optionally explains the most likely cause of the error.
+	Hint string
+}
+
+// Error implements the builtin/error interface.
+func (e CredentialError) Error() string {
+	msg := fmt.Sprintf("could not %s for credential %q: %s", e.Action, e.Credential.String(), e.Inner.Error())
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+// Unwrap implements the interface implicitly used by errors.Is() and errors.As().
+func (e CredentialError) Unwrap() []error {
+	if e.Category == nil {
+		return []error{e.Inner}
+	}
+	return []error{e.Category, e.Inner}
+}
+
+func keystoneError(cred CredentialID, action string, err error) CredentialError {
+	return CredentialError{
+		Credential: cred,
+		Action:     action,
+		Category:   classifyKeystoneError(err),
+		Inner:      err,
+	}
+}
+
+func decodeError(cred CredentialID, action string, err error) CredentialError {
+	return CredentialError{
+		Credential: cred,
+		Action:     action,
+		Category:   ErrDecode,
+		Inner:      err,
+	}
+}
+
+func memcacheError(cred CredentialID, action string, err error) CredentialError {
+	// memcached being unreachable (or all servers having failed) is
+	// considered transient; protocol errors are not
+	var category error
+	if isMemcacheServerFailure(err) || errors.Is(err, memcache.ErrNoServers) {
+		category = ErrTransient
+	}
+	return CredentialError{
+		Credential: cred,
+		Action:     action,
+		Category:   category,
+		Inner:      err,
+	}
+}
+
+// classifyKeystoneError chooses the category for an error returned by gophercloud.
+func classifyKeystoneError(err error) error {
+	var unexpectedCodeErr gophercloud.ErrUnexpectedResponseCode
+	switch {
+	case gophercloud.ResponseCodeIs(err, http.StatusNotFound):
+		return ErrNotFound
+	case gophercloud.ResponseCodeIs(err, http.StatusUnauthorized):
+		return ErrUnauthorized
+	case gophercloud.ResponseCodeIs(err, http.StatusForbidden):
+		return ErrForbidden
+	case gophercloud.ResponseCodeIs(err, http.StatusTooManyRequests):
+		return ErrTransient
+	case errors.As(err, &unexpectedCodeErr) && unexpectedCodeErr.Actual >= 500:
+		return ErrTransient
+	}
+
+	// errors from the network layer (connection refused, timeouts etc.)
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errCircuitOpen) {
+		return ErrTransient
+	}
+	return nil
+}
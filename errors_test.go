@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+func TestClassifyKeystoneError(t *testing.T) {
+	responseCode := func(code int) error {
+		return gophercloud.ErrUnexpectedResponseCode{Expected: []int{http.StatusOK}, Actual: code}
+	}
+	testCases := []struct {
+		Description      string
+		Error            error
+		ExpectedCategory error
+	}{
+		{"404", responseCode(http.StatusNotFound), ErrNotFound},
+		{"401", responseCode(http.StatusUnauthorized), ErrUnauthorized},
+		{"403", responseCode(http.StatusForbidden), ErrForbidden},
+		{"429", responseCode(http.StatusTooManyRequests), ErrTransient},
+		{"500", responseCode(http.StatusInternalServerError), ErrTransient},
+		{"503", responseCode(http.StatusServiceUnavailable), ErrTransient},
+		{"400", responseCode(http.StatusBadRequest), nil},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, ErrTransient},
+		{"timeout", context.DeadlineExceeded, ErrTransient},
+		{"other error", errors.New("something else"), nil},
+	}
+
+	for _, tc := range testCases {
+		category := classifyKeystoneError(tc.Error)
+		if category != tc.ExpectedCategory { //nolint:errorlint // comparing sentinels directly is intended here
+			t.Errorf("%s: expected category %v, but got %v", tc.Description, tc.ExpectedCategory, category)
+		}
+	}
+}
+
+func TestCredentialErrorCategories(t *testing.T) {
+	cred := CredentialID{UserID: "user1", AccessKey: "access1"}
+	allCategories := []error{ErrNotFound, ErrUnauthorized, ErrForbidden, ErrTransient, ErrDecode}
+	testCases := []struct {
+		Description      string
+		Error            error
+		ExpectedCategory error
+	}{
+		{"Keystone lookup", keystoneError(cred, "lookup EC2 credential in Keystone", gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusNotFound}), ErrNotFound},
+		{"unreachable memcached", memcacheError(cred, "fetch credential from Memcache", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), ErrTransient},
+		{"no memcached servers", memcacheError(cred, "fetch credential from Memcache", memcache.ErrNoServers), ErrTransient},
+		{"memcached protocol error", memcacheError(cred, "fetch credential from Memcache", memcache.ErrMalformedKey), nil},
+		{"corrupted cache entry", decodeError(cred, "decode credential payload from Memcache", errors.New("unexpected end of JSON input")), ErrDecode},
+	}
+
+	for _, tc := range testCases {
+		for _, category := range allCategories {
+			if errors.Is(tc.Error, category) != (category == tc.ExpectedCategory) { //nolint:errorlint // comparing sentinels directly is intended here
+				t.Errorf("%s: expected error category %v, but got error %q", tc.Description, tc.ExpectedCategory, tc.Error.Error())
+			}
+		}
+		// the inner error is still accessible
+		var credErr CredentialError
+		if !errors.As(tc.Error, &credErr) || credErr.Credential != cred {
+			t.Errorf("%s: expected CredentialError for %q, but got %#v", tc.Description, cred.String(), tc.Error)
+		}
+	}
+
+	err := CredentialError{Credential: cred, Action: "do something", Inner: errors.New("failed"), Hint: "try again"}
+	expected := `could not do something for credential "user1:access1": failed (try again)`
+	if err.Error() != expected {
+		t.Errorf("expected error message %q, but got %q", expected, err.Error())
+	}
+}
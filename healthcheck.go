@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HealthHandler serves the /healthz and /readyz endpoints of the prewarm
+// command. Both endpoints respond with a JSON object like
+//
+//	{"status":"ok","checks":{"loop":{"ok":true,"message":"..."}}}
+//
+// and with status 200 if all checks succeeded, or 503 otherwise.
+type HealthHandler struct {
+	Prewarmer *Prewarmer
+	// nil if the health probes for memcached servers are disabled
+	MemcacheHealth *memcacheHealthChecker
+	// MinFreshRatio is the fraction of credentials that must have been
+	// prewarmed within their expiry for /readyz to succeed.
+	MinFreshRatio float64
+	// StartedAt is when the process started. Until the first prewarm cycle
+	// starts, the prewarm loop is considered to have started at this time.
+	StartedAt time.Time
+}
+
+type healthCheckResult struct {
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+type healthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]healthCheckResult `json:"checks"`
+}
+
+// AddTo registers the endpoints in the given mux.
+func (h HealthHandler) AddTo(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		respondWithHealthReport(w, map[string]healthCheckResult{
+			"loop": h.checkLoop(time.Now()),
+		})
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		now := time.Now()
+		respondWithHealthReport(w, map[string]healthCheckResult{
+			"first_cycle":       h.checkFirstCycle(),
+			"keystone":          h.checkKeystone(now),
+			"memcache":          h.checkMemcache(),
+			"fresh_credentials": h.checkFreshCredentials(now),
+		})
+	})
+}
+
+func respondWithHealthReport(w http.ResponseWriter, checks map[string]healthCheckResult) {
+	report := healthReport{Status: "ok", Checks: checks}
+	status := http.StatusOK
+	for _, result := range checks {
+		if !result.OK {
+			report.Status = "failing"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSONResponse(w, status, report)
+}
+
+// checkLoop checks that the prewarm loop keeps starting new cycles. Since
+// each cycle is aborted when the next one is due, a new cycle should start at
+// least once per cycle length, plus the time needed to finish the refreshes
+// that were in progress.
+func (h HealthHandler) checkLoop(now time.Time) healthCheckResult {
+	startedAt, cycleLength, _ := h.Prewarmer.CycleStatus()
+	what := "last prewarm cycle started"
+	if startedAt.IsZero() {
+		startedAt = h.StartedAt
+		cycleLength = h.Prewarmer.CycleLength()
+		what = "no prewarm cycle has started since startup"
+	}
+	age := now.Sub(startedAt).Truncate(time.Second)
+	maxAge := 2*cycleLength + h.Prewarmer.RequestTimeout
+	if age > maxAge {
+		return healthCheckResult{false, fmt.Sprintf("%s %s ago, but a new cycle is due every %s", what, age, cycleLength)}
+	}
+	return healthCheckResult{true, fmt.Sprintf("%s %s ago (cycle length %s)", what, age, cycleLength)}
+}
+
+func (h HealthHandler) checkFirstCycle() healthCheckResult {
+	_, _, completedCycles := h.Prewarmer.CycleStatus()
+	if completedCycles == 0 {
+		return healthCheckResult{false, "first prewarm cycle has not completed yet"}
+	}
+	return healthCheckResult{true, fmt.Sprintf("%d prewarm cycles completed", completedCycles)}
+}
+
+func (h HealthHandler) checkKeystone(now time.Time) healthCheckResult {
+	lastCallAt, err := h.Prewarmer.Keystone.Reachability()
+	if lastCallAt.IsZero() {
+		if len(h.Prewarmer.Credentials()) == 0 {
+			// e.g. when discovery selectors do not match anything (yet), nothing needs Keystone
+			return healthCheckResult{true, "Keystone has not been called yet, but no credentials are being prewarmed"}
+		}
+		return healthCheckResult{false, "Keystone has not been called yet"}
+	}
+	age := now.Sub(lastCallAt).Truncate(time.Second)
+	if err != nil {
+		return healthCheckResult{false, fmt.Sprintf("last call to Keystone (%s ago) failed: %s", age, err.Error())}
+	}
+	return healthCheckResult{true, fmt.Sprintf("Keystone responded to the last call (%s ago)", age)}
+}
+
+func (h HealthHandler) checkMemcache() healthCheckResult {
+	if h.MemcacheHealth == nil {
+		return healthCheckResult{true, "health probes for memcached servers are disabled"}
+	}
+	up, total := h.MemcacheHealth.UpServers()
+	switch {
+	case total == 0:
+		return healthCheckResult{false, "memcached servers have not been probed yet"}
+	case up == 0:
+		return healthCheckResult{false, fmt.Sprintf("all %d memcached servers are down", total)}
+	default:
+		return healthCheckResult{true, fmt.Sprintf("%d of %d memcached servers are up", up, total)}
+	}
+}
+
+func (h HealthHandler) checkFreshCredentials(now time.Time) healthCheckResult {
+	fresh, total := h.Prewarmer.CountFreshCredentials(now)
+	if total == 0 {
+		return healthCheckResult{true, "no credentials are being prewarmed"}
+	}
+	msg := fmt.Sprintf("%d of %d credentials were prewarmed within their expiry (at least %g%% required)",
+		fresh, total, h.MinFreshRatio*100)
+	return healthCheckResult{float64(fresh) >= h.MinFreshRatio*float64(total), msg}
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthHandler(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	p, fk, _ := newTestPrewarmer(t, cred)
+	memcacheHealth := &memcacheHealthChecker{Memcache: p.Memcache}
+	mux := newTestHealthMux(p, memcacheHealth)
+	expectReport := func(path string, expectedStatus int, expectedChecks map[string]bool) {
+		t.Helper()
+		expectHealthReport(t, mux, path, expectedStatus, expectedChecks)
+	}
+
+	// before the first cycle, the prewarmer is alive, but not ready
+	expectReport("/healthz", http.StatusOK, map[string]bool{"loop": true})
+	expectReport("/readyz", http.StatusServiceUnavailable, map[string]bool{
+		"first_cycle":       false,
+		"keystone":          false,
+		"memcache":          false,
+		"fresh_credentials": false,
+	})
+
+	// after the first cycle, it is ready
+	p.doPrewarmCycle(t.Context(), 0)
+	memcacheHealth.CheckAll(t.Context())
+	expectReport("/readyz", http.StatusOK, map[string]bool{
+		"first_cycle":       true,
+		"keystone":          true,
+		"memcache":          true,
+		"fresh_credentials": true,
+	})
+
+	// a non-transient error from Keystone shows that Keystone is reachable...
+	fk.LookupStatus = http.StatusNotFound
+	p.doPrewarmCycle(t.Context(), 0)
+	expectReport("/readyz", http.StatusOK, map[string]bool{"keystone": true})
+
+	// ...but a transient error does not
+	fk.LookupStatus = http.StatusServiceUnavailable
+	p.doPrewarmCycle(t.Context(), 0)
+	expectReport("/readyz", http.StatusServiceUnavailable, map[string]bool{"keystone": false, "fresh_credentials": true})
+
+	// when no credentials are being prewarmed (e.g. because discovery
+	// selectors do not match anything), Keystone is never called, but that
+	// does not make the prewarmer unready
+	p0, _, _ := newTestPrewarmer(t)
+	mux0 := newTestHealthMux(p0, memcacheHealth)
+	p0.doPrewarmCycle(t.Context(), 0)
+	expectHealthReport(t, mux0, "/readyz", http.StatusOK, map[string]bool{
+		"first_cycle":       true,
+		"keystone":          true,
+		"fresh_credentials": true,
+	})
+
+	// when no cycle has been started for too long, the prewarm loop is considered stuck
+	p.mutex.Lock()
+	p.cycleStartedAt = time.Now().Add(-time.Hour)
+	p.cycleLength = time.Minute
+	p.mutex.Unlock()
+	expectReport("/healthz", http.StatusServiceUnavailable, map[string]bool{"loop": false})
+}
+
+func newTestHealthMux(p *Prewarmer, memcacheHealth *memcacheHealthChecker) *http.ServeMux {
+	mux := http.NewServeMux()
+	HealthHandler{
+		Prewarmer:      p,
+		MemcacheHealth: memcacheHealth,
+		MinFreshRatio:  1,
+		StartedAt:      time.Now(),
+	}.AddTo(mux)
+	return mux
+}
+
+func expectHealthReport(t *testing.T, mux *http.ServeMux, path string, expectedStatus int, expectedChecks map[string]bool) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	if rec.Code != expectedStatus {
+		t.Errorf("expected GET %s to return %d, but got %d: %s", path, expectedStatus, rec.Code, rec.Body.String())
+	}
+	var report healthReport
+	err := json.Unmarshal(rec.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for name, expectedOK := range expectedChecks {
+		if report.Checks[name].OK != expectedOK {
+			t.Errorf("expected check %q in GET %s to have ok = %t, but got: %#v", name, path, expectedOK, report.Checks[name])
+		}
+	}
+}
@@ -5,14 +5,17 @@ package main
 
 import (
 	"context"
-	"fmt"
-	"net/http"
+	"errors"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gophercloud/gophercloud/v2"
 	"github.com/gophercloud/gophercloud/v2/openstack"
 	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/ec2credentials"
 	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/ec2tokens"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/tokens"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sapcc/go-bits/gophercloudext"
 	"github.com/sapcc/go-bits/logg"
 )
@@ -26,35 +29,195 @@ func MustConnectToKeystone(ctx context.Context) *gophercloud.ServiceClient {
 	return identityV3
 }
 
+var keystoneCallsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "swift_s3_cache_prewarm_keystone_calls_total",
+		Help: `Counts calls to Keystone for prewarming credentials, by API ("ec2credentials_get" or "ec2tokens_create").`,
+	},
+	[]string{"api"},
+)
+
+var keystoneCallDurationSecsHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "swift_s3_cache_prewarm_keystone_call_duration_secs",
+		Help:    `Duration in seconds of calls to Keystone for prewarming credentials, by API ("ec2credentials_get" or "ec2tokens_create").`,
+		Buckets: prometheus.DefBuckets,
+	},
+	[]string{"api"},
+)
+
+// KeystoneClient is the client used by GetCredentialFromKeystone().
+type KeystoneClient struct {
+	IdentityV3 *gophercloud.ServiceClient
+	// If not nil, all calls made by GetCredentialFromKeystone() are subject
+	// to this rate limit and circuit breaker.
+	RateLimiter    *RateLimiter
+	CircuitBreaker *CircuitBreaker
+	// If not nil, secrets of EC2 credentials are only looked up in Keystone
+	// when this cache does not have them.
+	SecretCache *SecretCache
+
+	// the outcome of the most recent call (see Reachability())
+	mutex       sync.Mutex
+	lastCallAt  time.Time
+	lastCallErr error
+}
+
+// Reachability reports when Keystone was last called, and the error from
+// that call if it indicated that Keystone is unreachable (i.e. was transient).
+func (kc *KeystoneClient) Reachability() (lastCallAt time.Time, err error) {
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+	return kc.lastCallAt, kc.lastCallErr
+}
+
+func (kc *KeystoneClient) recordReachability(err error) {
+	if errors.Is(err, context.Canceled) {
+		// we gave up on our own, so this does not tell us anything about Keystone
+		return
+	}
+	if classifyKeystoneError(err) != ErrTransient {
+		// any response from Keystone (even an error) shows that it is reachable
+		err = nil
+	}
+	kc.mutex.Lock()
+	defer kc.mutex.Unlock()
+	kc.lastCallAt = time.Now()
+	kc.lastCallErr = err
+}
+
+// call executes a single call to Keystone.
+func (kc *KeystoneClient) call(ctx context.Context, api string, action func() error) error {
+	if kc.RateLimiter != nil {
+		err := kc.RateLimiter.Wait(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	if kc.CircuitBreaker == nil {
+		err := observeKeystoneCall(api, action)
+		kc.recordReachability(err)
+		return err
+	}
+	err := kc.CircuitBreaker.Allow()
+	if err != nil {
+		kc.recordReachability(err)
+		return err
+	}
+	err = observeKeystoneCall(api, action)
+	kc.CircuitBreaker.Record(err)
+	kc.recordReachability(err)
+	return err
+}
+
+func observeKeystoneCall(api string, action func() error) error {
+	keystoneCallsCounter.WithLabelValues(api).Inc()
+	start := time.Now()
+	err := action()
+	keystoneCallDurationSecsHistogram.WithLabelValues(api).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// lookupSecret looks up the secret of an EC2 credential in Keystone, and
+// updates the SecretCache accordingly.
+func (kc *KeystoneClient) lookupSecret(ctx context.Context, cred CredentialID) (string, error) {
+	var credInfo *ec2credentials.Credential
+	err := kc.call(ctx, "ec2credentials_get", func() (err error) {
+		credInfo, err = ec2credentials.Get(ctx, kc.IdentityV3, cred.UserID, cred.AccessKey).Extract()
+		return err
+	})
+	if err != nil {
+		err := keystoneError(cred, "lookup EC2 credential in Keystone", err)
+		if kc.SecretCache != nil && errors.Is(err, ErrNotFound) {
+			kc.SecretCache.Forget(cred)
+		}
+		return "", err
+	}
+	if kc.SecretCache != nil {
+		kc.SecretCache.Put(cred, credInfo.Secret)
+	}
+	return credInfo.Secret, nil
+}
+
+// login obtains a token for an EC2 credential from Keystone.
+func (kc *KeystoneClient) login(ctx context.Context, cred CredentialID, secret string) (tokens.CreateResult, error) {
+	var result tokens.CreateResult
+	err := kc.call(ctx, "ec2tokens_create", func() error {
+		result = ec2tokens.Create(ctx, kc.IdentityV3, &ec2tokens.AuthOptions{
+			Access: cred.AccessKey,
+			Secret: secret,
+		})
+		return result.Err
+	})
+	if err != nil {
+		err := keystoneError(cred, "login as EC2 credential in Keystone", err)
+		switch {
+		case errors.Is(err, ErrUnauthorized):
+			err.Hint = "credential is disabled or secret does not match"
+		case errors.Is(err, ErrForbidden):
+			err.Hint = "project is disabled"
+		}
+		return result, err
+	}
+	return result, nil
+}
+
 // GetCredentialFromKeystone fetches an EC2 credential from Keystone.
-// Returns nil if the credential does not exist.
-func GetCredentialFromKeystone(ctx context.Context, identityV3 *gophercloud.ServiceClient, cred CredentialID) *CredentialPayload {
-	// get secret from Keystone
-	credInfo, err := ec2credentials.Get(ctx, identityV3, cred.UserID, cred.AccessKey).Extract()
-	if gophercloud.ResponseCodeIs(err, http.StatusNotFound) {
-		logg.Info("skipping credential %q: not found in Keystone", cred.String())
-		return nil
+// If the credential does not exist, a CredentialError with category ErrNotFound is returned.
+func GetCredentialFromKeystone(ctx context.Context, kc *KeystoneClient, cred CredentialID) (*CredentialPayload, error) {
+	// get secret from Keystone (or from our cache)
+	var (
+		secret   string
+		isCached bool
+		err      error
+	)
+	if kc.SecretCache != nil {
+		secret, isCached = kc.SecretCache.Get(cred)
+	}
+	if !isCached {
+		secret, err = kc.lookupSecret(ctx, cred)
+		if err != nil {
+			return nil, err
+		}
 	}
-	mustDo(fmt.Sprintf(`lookup EC2 credential %q in Keystone`, cred.String()), err)
 
 	// login with this credential to get further information
-	result := ec2tokens.Create(ctx, identityV3, &ec2tokens.AuthOptions{
-		Access: cred.AccessKey,
-		Secret: credInfo.Secret,
-	})
-	if gophercloud.ResponseCodeIs(err, http.StatusUnauthorized) {
-		logg.Info("skipping credential %q: authorization failed", cred.String())
-		return nil
+	result, err := kc.login(ctx, cred, secret)
+	if isCached && errors.Is(err, ErrUnauthorized) {
+		// the secret may have changed since we cached it, or the credential
+		// may have been deleted, so we need to look it up again
+		freshSecret, lookupErr := kc.lookupSecret(ctx, cred)
+		switch {
+		case lookupErr != nil:
+			return nil, lookupErr
+		case freshSecret != secret:
+			secret = freshSecret
+			result, err = kc.login(ctx, cred, secret)
+		}
+	}
+	if err != nil {
+		return nil, err
 	}
-	mustDo(fmt.Sprintf(`login as EC2 credential %q in Keystone`, cred.String()), err)
 
 	// convert into the payload format used by the token cache
 	project, err := result.ExtractProject()
-	mustDo(fmt.Sprintf("extract project data for EC2 credential %q", cred.String()), err)
+	if err == nil && project == nil {
+		err = errors.New("token is not scoped to a project")
+	}
+	if err != nil {
+		return nil, decodeError(cred, "extract project data from Keystone token", err)
+	}
 	user, err := result.ExtractUser()
-	mustDo(fmt.Sprintf("extract user data for EC2 credential %q", cred.String()), err)
+	if err == nil && user == nil {
+		err = errors.New("token does not contain user data")
+	}
+	if err != nil {
+		return nil, decodeError(cred, "extract user data from Keystone token", err)
+	}
 	roles, err := result.ExtractRoles()
-	mustDo(fmt.Sprintf("extract role data for EC2 credential %q", cred.String()), err)
+	if err != nil {
+		return nil, decodeError(cred, "extract role data from Keystone token", err)
+	}
 	roleNames := make([]string, len(roles))
 	for idx, role := range roles {
 		roleNames[idx] = role.Name
@@ -76,8 +239,8 @@ func GetCredentialFromKeystone(ctx context.Context, identityV3 *gophercloud.Serv
 			"X-Project-Domain-Name": project.Domain.Name,
 		},
 		Project: *project,
-		Secret:  credInfo.Secret,
-	}
+		Secret:  secret,
+	}, nil
 }
 
 func mustDo(action string, err error) {
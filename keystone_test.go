@@ -0,0 +1,498 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+	"github.com/gophercloud/gophercloud/v2/openstack/identity/v3/ec2tokens"
+)
+
+// fakeKeystone implements the parts of the Keystone API that we use.
+type fakeKeystone struct {
+	mutex sync.Mutex
+	// key = credential, value = secret
+	Credentials map[CredentialID]string
+	// If not zero, all GET requests for EC2 credentials fail with this status code.
+	LookupStatus int
+	// The number of GET requests for EC2 credentials so far.
+	LookupCount int
+	// If not zero, all EC2 logins fail with this status code.
+	LoginStatus int
+	// The number of EC2 logins so far.
+	LoginCount int
+	// If true, EC2 logins return a token without project scope.
+	LoginWithoutProject bool
+	// If not zero, EC2 logins take this long (unless the client gives up earlier).
+	LoginDelay time.Duration
+	// The maximum number of EC2 logins that were in progress at the same time.
+	MaxConcurrentLogins int
+	concurrentLogins    int
+
+	// The following fields are only used by the APIs used for credential discovery.
+	Users              map[string]string // key = user ID, value = user name
+	Domains            map[string]string // key = domain ID, value = domain name
+	Projects           []fakeProject
+	RoleAssignments    map[string][]string     // key = project ID, value = user IDs
+	CredentialProjects map[CredentialID]string // value = project ID (defaults to "project1")
+}
+
+type fakeProject struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	DomainID string   `json:"domain_id"`
+	Tags     []string `json:"tags"`
+}
+
+func newFakeKeystone(t *testing.T) (*fakeKeystone, *gophercloud.ServiceClient) {
+	t.Helper()
+	fk := &fakeKeystone{
+		Credentials:        make(map[CredentialID]string),
+		Users:              make(map[string]string),
+		Domains:            make(map[string]string),
+		RoleAssignments:    make(map[string][]string),
+		CredentialProjects: make(map[CredentialID]string),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v3/users/{userid}/credentials/OS-EC2/{accesskey}", fk.handleGetCredential)
+	mux.HandleFunc("GET /v3/users/{userid}/credentials/OS-EC2", fk.handleListCredentials)
+	mux.HandleFunc("POST /v3/ec2tokens", fk.handleLogin)
+	mux.HandleFunc("GET /v3/users/{userid}", fk.handleGetUser)
+	mux.HandleFunc("GET /v3/users", fk.handleListUsers)
+	mux.HandleFunc("GET /v3/projects/{projectid}", fk.handleGetProject)
+	mux.HandleFunc("GET /v3/projects", fk.handleListProjects)
+	mux.HandleFunc("GET /v3/domains/{domainid}", fk.handleGetDomain)
+	mux.HandleFunc("GET /v3/domains", fk.handleListDomains)
+	mux.HandleFunc("GET /v3/role_assignments", fk.handleListRoleAssignments)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client := &gophercloud.ServiceClient{
+		ProviderClient: &gophercloud.ProviderClient{HTTPClient: *server.Client()},
+		Endpoint:       server.URL + "/v3/",
+	}
+	return fk, client
+}
+
+func (fk *fakeKeystone) handleGetCredential(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	fk.LookupCount++
+	if fk.LookupStatus != 0 {
+		http.Error(w, "injected error", fk.LookupStatus)
+		return
+	}
+	cred := CredentialID{UserID: r.PathValue("userid"), AccessKey: r.PathValue("accesskey")}
+	secret, exists := fk.Credentials[cred]
+	if !exists {
+		http.Error(w, "no such credential", http.StatusNotFound)
+		return
+	}
+	respondWithJSON(w, map[string]any{"credential": fk.renderCredential(cred, secret)})
+}
+
+func (fk *fakeKeystone) handleListCredentials(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	result := []any{}
+	for cred, secret := range fk.Credentials {
+		if cred.UserID == r.PathValue("userid") {
+			result = append(result, fk.renderCredential(cred, secret))
+		}
+	}
+	respondWithJSON(w, map[string]any{"credentials": result, "links": map[string]any{}})
+}
+
+func (fk *fakeKeystone) renderCredential(cred CredentialID, secret string) map[string]any {
+	projectID := fk.CredentialProjects[cred]
+	if projectID == "" {
+		projectID = "project1"
+	}
+	return map[string]any{
+		"user_id":   cred.UserID,
+		"tenant_id": projectID,
+		"access":    cred.AccessKey,
+		"secret":    secret,
+	}
+}
+
+func (fk *fakeKeystone) handleGetUser(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	userID := r.PathValue("userid")
+	userName, exists := fk.Users[userID]
+	if !exists {
+		http.Error(w, "no such user", http.StatusNotFound)
+		return
+	}
+	respondWithJSON(w, map[string]any{"user": map[string]any{"id": userID, "name": userName}})
+}
+
+func (fk *fakeKeystone) handleListUsers(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	result := []any{}
+	for userID, userName := range fk.Users {
+		if userName == r.URL.Query().Get("name") {
+			result = append(result, map[string]any{"id": userID, "name": userName})
+		}
+	}
+	respondWithJSON(w, map[string]any{"users": result, "links": map[string]any{}})
+}
+
+func (fk *fakeKeystone) handleGetProject(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	for _, project := range fk.Projects {
+		if project.ID == r.PathValue("projectid") {
+			respondWithJSON(w, map[string]any{"project": project})
+			return
+		}
+	}
+	http.Error(w, "no such project", http.StatusNotFound)
+}
+
+func (fk *fakeKeystone) handleListProjects(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	query := r.URL.Query()
+	result := []any{}
+	for _, project := range fk.Projects {
+		switch {
+		case query.Has("name") && query.Get("name") != project.Name:
+			continue
+		case query.Has("domain_id") && query.Get("domain_id") != project.DomainID:
+			continue
+		case query.Has("tags") && !slices.Contains(project.Tags, query.Get("tags")):
+			continue
+		}
+		result = append(result, project)
+	}
+	respondWithJSON(w, map[string]any{"projects": result, "links": map[string]any{}})
+}
+
+func (fk *fakeKeystone) handleGetDomain(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	domainID := r.PathValue("domainid")
+	domainName, exists := fk.Domains[domainID]
+	if !exists {
+		http.Error(w, "no such domain", http.StatusNotFound)
+		return
+	}
+	respondWithJSON(w, map[string]any{"domain": map[string]any{"id": domainID, "name": domainName}})
+}
+
+func (fk *fakeKeystone) handleListDomains(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	result := []any{}
+	for domainID, domainName := range fk.Domains {
+		if domainName == r.URL.Query().Get("name") {
+			result = append(result, map[string]any{"id": domainID, "name": domainName})
+		}
+	}
+	respondWithJSON(w, map[string]any{"domains": result, "links": map[string]any{}})
+}
+
+func (fk *fakeKeystone) handleListRoleAssignments(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	projectID := r.URL.Query().Get("scope.project.id")
+	result := []any{}
+	for _, userID := range fk.RoleAssignments[projectID] {
+		result = append(result, map[string]any{
+			"role":  map[string]any{"id": "role1"},
+			"scope": map[string]any{"project": map[string]any{"id": projectID}},
+			"user":  map[string]any{"id": userID},
+		})
+	}
+	respondWithJSON(w, map[string]any{"role_assignments": result, "links": map[string]any{}})
+}
+
+func (fk *fakeKeystone) handleLogin(w http.ResponseWriter, r *http.Request) {
+	fk.mutex.Lock()
+	fk.concurrentLogins++
+	fk.MaxConcurrentLogins = max(fk.MaxConcurrentLogins, fk.concurrentLogins)
+	delay := fk.LoginDelay
+	fk.mutex.Unlock()
+	defer func() {
+		fk.mutex.Lock()
+		fk.concurrentLogins--
+		fk.mutex.Unlock()
+	}()
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	fk.mutex.Lock()
+	defer fk.mutex.Unlock()
+
+	fk.LoginCount++
+	var req struct {
+		Credentials struct {
+			Access    string            `json:"access"`
+			Verb      string            `json:"verb"`
+			Path      string            `json:"path"`
+			Params    map[string]string `json:"params"`
+			Headers   map[string]string `json:"headers"`
+			BodyHash  string            `json:"body_hash"`
+			Signature string            `json:"signature"`
+		} `json:"credentials"`
+	}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fk.LoginStatus != 0 {
+		http.Error(w, "injected error", fk.LoginStatus)
+		return
+	}
+
+	// check the signature like Keystone does for AWS signature V4 (with the
+	// simplifications that gophercloud does not sign any headers, and that
+	// region and service are empty)
+	c := req.Credentials
+	date, err := time.Parse(ec2tokens.EC2CredentialsTimestampFormatV4, c.Headers["X-Amz-Date"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var userID string
+	for cred, secret := range fk.Credentials {
+		if cred.AccessKey != c.Access {
+			continue
+		}
+		opts := ec2tokens.AuthOptions{Verb: c.Verb, Path: c.Path, Params: c.Params}
+		key := ec2tokens.EC2CredentialsBuildSignatureKeyV4(secret, "", "", date)
+		stringToSign := ec2tokens.EC2CredentialsBuildStringToSignV4(opts, "", c.BodyHash, date)
+		if ec2tokens.EC2CredentialsBuildSignatureV4(key, stringToSign) == c.Signature {
+			userID = cred.UserID
+		}
+	}
+	if userID == "" {
+		http.Error(w, "invalid credential", http.StatusUnauthorized)
+		return
+	}
+
+	token := map[string]any{
+		"user": map[string]any{
+			"id":     userID,
+			"name":   "user1",
+			"domain": map[string]any{"id": "domain1", "name": "Domain 1"},
+		},
+		"roles": []map[string]any{
+			{"id": "role1", "name": "member"},
+			{"id": "role2", "name": "reader"},
+		},
+	}
+	if !fk.LoginWithoutProject {
+		token["project"] = map[string]any{
+			"id":     "project1",
+			"name":   "Project 1",
+			"domain": map[string]any{"id": "domain1", "name": "Domain 1"},
+		}
+	}
+	w.Header().Set("X-Subject-Token", "dummy-token")
+	respondWithJSON(w, map[string]any{"token": token})
+}
+
+func respondWithJSON(w http.ResponseWriter, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(data) //nolint:errcheck // only used in tests
+}
+
+func TestGetCredentialFromKeystone(t *testing.T) {
+	fk, identityV3 := newFakeKeystone(t)
+	cred := CredentialID{UserID: "user1", AccessKey: "access1"}
+	fk.Credentials[cred] = "secret1"
+
+	payload, err := GetCredentialFromKeystone(t.Context(), &KeystoneClient{IdentityV3: identityV3}, cred)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expectedHeaders := map[string]string{
+		"X-Identity-Status":     "Confirmed",
+		"X-Roles":               "member,reader",
+		"X-User-Id":             "user1",
+		"X-User-Name":           "user1",
+		"X-User-Domain-Id":      "domain1",
+		"X-User-Domain-Name":    "Domain 1",
+		"X-Tenant-Id":           "project1",
+		"X-Tenant-Name":         "Project 1",
+		"X-Project-Id":          "project1",
+		"X-Project-Name":        "Project 1",
+		"X-Project-Domain-Id":   "domain1",
+		"X-Project-Domain-Name": "Domain 1",
+	}
+	for key, expected := range expectedHeaders {
+		if payload.Headers[key] != expected {
+			t.Errorf("expected header %s to be %q, but got %q", key, expected, payload.Headers[key])
+		}
+	}
+	if payload.Secret != "secret1" {
+		t.Errorf("expected secret %q, but got %q", "secret1", payload.Secret)
+	}
+	if payload.Project.ID != "project1" {
+		t.Errorf("expected project ID %q, but got %q", "project1", payload.Project.ID)
+	}
+}
+
+func TestGetCredentialFromKeystoneErrors(t *testing.T) {
+	testCases := []struct {
+		Description      string
+		Setup            func(fk *fakeKeystone)
+		ExpectedCategory error
+		ExpectedMessage  string
+	}{
+		{
+			Description:      "credential does not exist",
+			Setup:            func(fk *fakeKeystone) { delete(fk.Credentials, CredentialID{"user1", "access1"}) },
+			ExpectedCategory: ErrNotFound,
+			ExpectedMessage:  "could not lookup EC2 credential in Keystone",
+		},
+		{
+			Description:      "lookup fails with server error",
+			Setup:            func(fk *fakeKeystone) { fk.LookupStatus = http.StatusInternalServerError },
+			ExpectedCategory: ErrTransient,
+			ExpectedMessage:  "could not lookup EC2 credential in Keystone",
+		},
+		{
+			Description:      "login is rejected",
+			Setup:            func(fk *fakeKeystone) { fk.LoginStatus = http.StatusUnauthorized },
+			ExpectedCategory: ErrUnauthorized,
+			ExpectedMessage:  "(credential is disabled or secret does not match)",
+		},
+		{
+			Description:      "project is disabled",
+			Setup:            func(fk *fakeKeystone) { fk.LoginStatus = http.StatusForbidden },
+			ExpectedCategory: ErrForbidden,
+			ExpectedMessage:  "(project is disabled)",
+		},
+		{
+			Description:      "login fails with server error",
+			Setup:            func(fk *fakeKeystone) { fk.LoginStatus = http.StatusInternalServerError },
+			ExpectedCategory: ErrTransient,
+			ExpectedMessage:  "could not login as EC2 credential in Keystone",
+		},
+		{
+			Description:      "login fails with service unavailable",
+			Setup:            func(fk *fakeKeystone) { fk.LoginStatus = http.StatusServiceUnavailable },
+			ExpectedCategory: ErrTransient,
+			ExpectedMessage:  "could not login as EC2 credential in Keystone",
+		},
+		{
+			Description:      "login returns unscoped token",
+			Setup:            func(fk *fakeKeystone) { fk.LoginWithoutProject = true },
+			ExpectedCategory: ErrDecode,
+			ExpectedMessage:  "could not extract project data from Keystone token",
+		},
+	}
+
+	allCategories := []error{ErrNotFound, ErrUnauthorized, ErrForbidden, ErrTransient, ErrDecode}
+	for _, tc := range testCases {
+		fk, identityV3 := newFakeKeystone(t)
+		cred := CredentialID{UserID: "user1", AccessKey: "access1"}
+		fk.Credentials[cred] = "secret1"
+		tc.Setup(fk)
+
+		payload, err := GetCredentialFromKeystone(t.Context(), &KeystoneClient{IdentityV3: identityV3}, cred)
+		if err == nil {
+			t.Errorf("%s: expected error, but got payload %#v", tc.Description, payload)
+			continue
+		}
+		if payload != nil {
+			t.Errorf("%s: expected no payload alongside error, but got %#v", tc.Description, payload)
+		}
+		for _, category := range allCategories {
+			if errors.Is(err, category) != (category == tc.ExpectedCategory) { //nolint:errorlint // comparing sentinels directly is intended here
+				t.Errorf("%s: expected error category %q, but got error %q", tc.Description, tc.ExpectedCategory.Error(), err.Error())
+			}
+		}
+		if !strings.Contains(err.Error(), tc.ExpectedMessage) {
+			t.Errorf("%s: expected error message to contain %q, but got %q", tc.Description, tc.ExpectedMessage, err.Error())
+		}
+	}
+}
+
+func TestSecretCache(t *testing.T) {
+	fk, identityV3 := newFakeKeystone(t)
+	cred := CredentialID{UserID: "user1", AccessKey: "access1"}
+	fk.Credentials[cred] = "secret1"
+	now := time.Unix(1000000, 0)
+	kc := &KeystoneClient{IdentityV3: identityV3, SecretCache: NewSecretCache(time.Hour)}
+	kc.SecretCache.now = func() time.Time { return now }
+
+	expect := func(expectedSecret string, expectedLookups, expectedLogins int) {
+		t.Helper()
+		payload, err := GetCredentialFromKeystone(t.Context(), kc, cred)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if payload.Secret != expectedSecret {
+			t.Errorf("expected secret %q, but got %q", expectedSecret, payload.Secret)
+		}
+		if fk.LookupCount != expectedLookups || fk.LoginCount != expectedLogins {
+			t.Errorf("expected %d lookups and %d logins, but got %d lookups and %d logins",
+				expectedLookups, expectedLogins, fk.LookupCount, fk.LoginCount)
+		}
+	}
+
+	// the secret is only looked up once per revalidation interval
+	expect("secret1", 1, 1)
+	now = now.Add(59 * time.Minute)
+	expect("secret1", 1, 2)
+	now = now.Add(1 * time.Minute)
+	expect("secret1", 2, 3)
+
+	// when the secret changes, the login with the cached secret fails, so the secret is looked up again
+	fk.Credentials[cred] = "secret2"
+	expect("secret2", 3, 5)
+
+	// when the credential is deleted, this is detected by the repeated lookup
+	delete(fk.Credentials, cred)
+	_, err := GetCredentialFromKeystone(t.Context(), kc, cred)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, but got %v", err)
+	}
+	if _, exists := kc.SecretCache.Get(cred); exists {
+		t.Error("expected secret of deleted credential to be removed from the cache")
+	}
+
+	// when the login fails for other reasons, the secret is only looked up once
+	fk.Credentials[cred] = "secret2"
+	fk.LookupCount, fk.LoginCount = 0, 0
+	expect("secret2", 1, 1)
+	fk.LoginStatus = http.StatusUnauthorized
+	_, err = GetCredentialFromKeystone(t.Context(), kc, cred)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("expected ErrUnauthorized, but got %v", err)
+	}
+	if fk.LookupCount != 2 || fk.LoginCount != 2 {
+		t.Errorf("expected 2 lookups and 2 logins, but got %d lookups and %d logins", fk.LookupCount, fk.LoginCount)
+	}
+}
@@ -6,13 +6,14 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
-	"github.com/bradfitz/gomemcache/memcache"
-	"github.com/gophercloud/gophercloud/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sapcc/go-api-declarations/bininfo"
@@ -24,9 +25,35 @@ import (
 )
 
 var flagConservative bool
+var flagEvict bool
+var flagConcurrency int
+var flagMaxStaleness time.Duration
+var flagKeystoneRateLimit float64
+var flagKeystoneBurst int
+var flagKeystoneBreakerThreshold int
+var flagKeystoneBreakerBackoff time.Duration
+var flagKeystoneSecretRevalidationInterval time.Duration
+var flagRequestTimeout time.Duration
 var flagExpiryTime time.Duration
 var flagPromListenAddress string
+var flagReadyMinFreshRatio float64
+var flagAdminTokenFile string
+var flagOnce bool
+var flagDryRun bool
+var flagDiffJSON bool
 var flagMemcacheServers []string
+var flagMemcache MemcacheOptions
+var flagSwiftConfigPaths []string
+var flagMemcacheDNSInterval time.Duration
+var flagMemcacheHealthInterval time.Duration
+var flagDiscoverySelectors []string
+var flagDiscoveryInterval time.Duration
+var flagConfigPath string
+var flagCredentialsPath string
+
+// The backoff of the circuit breaker for Keystone calls (see
+// --keystone-breaker-backoff) doubles after each failed trial call, up to this limit.
+const keystoneBreakerMaxBackoff = 5 * time.Minute
 
 func main() {
 	logg.ShowDebug = osext.GetenvBool("SWIFT_S3CP_DEBUG")
@@ -44,7 +71,16 @@ func main() {
 			cmd.Help()
 		},
 	}
-	rootCmd.PersistentFlags().StringSliceVarP(&flagMemcacheServers, "servers", "s", []string{"localhost:11211"}, `List of memcached server endpoints (usually in "host:port" form).`)
+	rootCmd.PersistentFlags().StringSliceVarP(&flagMemcacheServers, "servers", "s", []string{"localhost:11211"}, `List of memcached server endpoints (usually in "host:port" form). Must be given exactly as in the "memcache_servers" option of Swift, since Swift's server selection depends on these strings. Entries of the form "dns+srv://<name>" are replaced by the targets of the SRV records for that name, and entries of the form "dns://<host>[:<port>]" are replaced by all addresses of that host.`)
+	rootCmd.PersistentFlags().StringSliceVar(&flagSwiftConfigPaths, "swift-config", nil, `Paths to Swift's configuration files (usually memcache.conf and/or proxy-server.conf). Memcache settings (from the "[memcache]" section or the memcache middleware section) and the "secret_cache_duration" of the s3token middleware are used as defaults for --servers, --memcache-* and --expiry.`)
+	rootCmd.PersistentFlags().DurationVar(&flagMemcache.ConnectTimeout, "memcache-connect-timeout", 300*time.Millisecond, `Timeout for connecting to a memcached server (like "connect_timeout" in Swift's memcache.conf).`)
+	rootCmd.PersistentFlags().DurationVar(&flagMemcache.IOTimeout, "memcache-io-timeout", 2*time.Second, `Timeout for reading from or writing to a memcached server (like "io_timeout" in Swift's memcache.conf).`)
+	rootCmd.PersistentFlags().IntVar(&flagMemcache.Tries, "memcache-tries", swiftTryCount, `Maximum number of memcached servers that are tried for each key (like "tries" in Swift's memcache.conf). Must be the same as in Swift, since it affects which servers cache entries are written to.`)
+	rootCmd.PersistentFlags().BoolVar(&flagMemcache.TLS.Enabled, "memcache-tls", false, `Connect to memcached with TLS (like "tls_enabled" in Swift's memcache.conf).`)
+	rootCmd.PersistentFlags().StringVar(&flagMemcache.TLS.CAFile, "memcache-tls-ca-file", "", `Path to a PEM file with the CA certificates for verifying memcached's server certificates (like "tls_cafile" in Swift's memcache.conf). If not given, the system's trusted CAs are used.`)
+	rootCmd.PersistentFlags().StringVar(&flagMemcache.TLS.CertFile, "memcache-tls-cert-file", "", `Path to a PEM file with a client certificate for memcached (like "tls_certfile" in Swift's memcache.conf).`)
+	rootCmd.PersistentFlags().StringVar(&flagMemcache.TLS.KeyFile, "memcache-tls-key-file", "", `Path to a PEM file with the private key for --memcache-tls-cert-file (like "tls_keyfile" in Swift's memcache.conf).`)
+	rootCmd.PersistentFlags().StringVar(&flagMemcache.TLS.ServerName, "memcache-tls-server-name", "", "Name that memcached's server certificates are verified against. If not given, the hostname from the respective server endpoint is used.")
 
 	checkKeystoneCmd := cobra.Command{
 		Use:   "check-keystone <userid:accesskey>...",
@@ -64,16 +100,46 @@ func main() {
 	}
 	rootCmd.AddCommand(&checkMemcachedCmd)
 
+	diffCmd := cobra.Command{
+		Use:   "diff <userid:accesskey>...",
+		Short: "Compare the given credentials in Keystone and Memcache (read-only).",
+		Long:  "Compare the given credentials in Keystone and Memcache (read-only). Prints the fields in which each cache entry differs from Keystone (with secrets redacted). Exits with status 0 if all cache entries are equal to Keystone, 2 if any cache entry is different, 3 if any credential is missing in Memcache, or 4 if any credential is missing in Keystone (the highest applicable status wins). Exits with status 1 if the comparison could not be completed.",
+		Args:  cobra.MinimumNArgs(1),
+		Run:   runDiff,
+	}
+	diffCmd.Flags().BoolVar(&flagDiffJSON, "json", false, "Print the result for each credential as JSON instead of in human-readable form.")
+	rootCmd.AddCommand(&diffCmd)
+
 	prewarmCmd := cobra.Command{
-		Use:   "prewarm <userid:accesskey>...",
+		Use:   "prewarm [<userid:accesskey>...]",
 		Short: "Keep the given credentials prewarmed in Memcache.",
-		Long:  "Keep the given credentials prewarmed in Memcache.",
-		Args:  cobra.MinimumNArgs(1),
+		Long:  "Keep the given credentials prewarmed in Memcache. Credentials can be given as arguments, in the config file (see --config) or credentials file (see --credentials-file), or discovered in Keystone with --discover. On SIGHUP or when the config file or credentials file changes, the list of credentials is reloaded.",
+		Args:  cobra.ArbitraryArgs,
 		Run:   runPrewarm,
 	}
-	prewarmCmd.Flags().BoolVar(&flagConservative, "conservative", false, "Do not touch Memcache when the existing cache entry conflicts with information from Keystone.")
+	prewarmCmd.Flags().StringVar(&flagConfigPath, "config", "", "Path to a config file in YAML or JSON format. Options given as flags take precedence over the respective options in the config file.")
+	prewarmCmd.Flags().StringVar(&flagCredentialsPath, "credentials-file", "", `Path to a file containing one "userid:accesskey" pair per line (in addition to the credentials given as arguments).`)
+	prewarmCmd.Flags().BoolVar(&flagConservative, "conservative", false, "Do not touch Memcache when the existing cache entry conflicts with information from Keystone. Cache entries are only updated with compare-and-swap, so that concurrent writes by Swift are never overwritten.")
+	prewarmCmd.Flags().BoolVar(&flagEvict, "evict", false, "Delete the cache entry of credentials that were deleted in Keystone or cannot be used to login anymore. With --conservative, only cache entries that match what was last written by this process are deleted.")
 	prewarmCmd.Flags().DurationVar(&flagExpiryTime, "expiry", 10*time.Minute, "Expiration cycle for Memcache entries. The prewarm will happen in intervals of 1/5 the expiration interval.")
-	prewarmCmd.Flags().StringVar(&flagPromListenAddress, "listen", "localhost:8080", "Listen address for HTTP server exposing Prometheus metrics.")
+	prewarmCmd.Flags().StringSliceVar(&flagDiscoverySelectors, "discover", nil, `Discover EC2 credentials in Keystone. Selectors have the form "<kind>:<id-or-name>" with kind being "user", "project", "domain" or "project-tag". For "user", all EC2 credentials of that user are selected. Otherwise, EC2 credentials are selected if they are scoped to one of the selected projects.`)
+	prewarmCmd.Flags().DurationVar(&flagDiscoveryInterval, "discovery-interval", 10*time.Minute, "Interval in which credential discovery (see --discover) is repeated.")
+	prewarmCmd.Flags().DurationVar(&flagMaxStaleness, "max-staleness", 0, "If not zero, extend the lifetime of cache entries while Keystone is unavailable, until the last successful verification in Keystone is this long ago.")
+	prewarmCmd.Flags().Float64Var(&flagKeystoneRateLimit, "keystone-rate-limit", 20, "Maximum number of Keystone calls per second for prewarming credentials (0 means no limit).")
+	prewarmCmd.Flags().IntVar(&flagKeystoneBurst, "keystone-burst", 20, "Maximum number of Keystone calls that may be made at once without regard to --keystone-rate-limit.")
+	prewarmCmd.Flags().IntVar(&flagKeystoneBreakerThreshold, "keystone-breaker-threshold", 5, "Number of consecutive transient Keystone failures (server errors, timeouts etc.) after which Keystone calls are suspended (0 disables the circuit breaker).")
+	prewarmCmd.Flags().DurationVar(&flagKeystoneBreakerBackoff, "keystone-breaker-backoff", 10*time.Second, "How long Keystone calls are suspended when the circuit breaker opens for the first time. Doubles for each consecutive failed trial call, up to 5 minutes.")
+	prewarmCmd.Flags().DurationVar(&flagKeystoneSecretRevalidationInterval, "keystone-secret-revalidation-interval", time.Hour, "How long the secrets of EC2 credentials are remembered between prewarm cycles before they are looked up in Keystone again (0 disables this cache, so that each refresh looks up the secret).")
+	prewarmCmd.Flags().IntVar(&flagMemcache.Replicas, "memcache-replicas", 1, "Number of memcached servers that each cache entry is written to, following the order in which Swift fails over to other servers (at most --memcache-tries). With --conservative, cache entries are only written to the first server.")
+	prewarmCmd.Flags().DurationVar(&flagMemcacheDNSInterval, "memcache-dns-interval", 30*time.Second, `Interval in which "dns+srv://" and "dns://" entries in --servers are resolved again.`)
+	prewarmCmd.Flags().DurationVar(&flagMemcacheHealthInterval, "memcache-health-interval", 30*time.Second, "Interval in which the health of each memcached server is probed (0 disables the health probes).")
+	prewarmCmd.Flags().IntVar(&flagConcurrency, "concurrency", 4, "Maximum number of credentials that are prewarmed at the same time by each prewarm cycle or immediate prewarm.")
+	prewarmCmd.Flags().DurationVar(&flagRequestTimeout, "request-timeout", 30*time.Second, "Maximum duration of the prewarm of a single credential (including all requests to Keystone).")
+	prewarmCmd.Flags().StringVar(&flagPromListenAddress, "listen", "localhost:8080", "Listen address for HTTP server exposing Prometheus metrics and the /healthz and /readyz endpoints.")
+	prewarmCmd.Flags().BoolVar(&flagOnce, "once", false, "Prewarm all credentials once (without spreading the refreshes over a prewarm cycle), print the result for each credential as JSON, and exit. Exits with a non-zero status if any credential could not be prewarmed.")
+	prewarmCmd.Flags().BoolVar(&flagDryRun, "dry-run", false, "Look up all credentials in Keystone and Memcache once, print for each credential as JSON what prewarming would do (create, refresh, rewrite-changed, skip-conflict, evict or error) and how the cache entry differs from Keystone, and exit without writing into Memcache. Exits with a non-zero status if prewarming any credential would fail (including skip-conflict, like with --once).")
+	prewarmCmd.Flags().StringVar(&flagAdminTokenFile, "admin-token-file", "", "Path to a file containing the bearer token for the admin API below /admin/ on the --listen address. If not given, the admin API is disabled.")
+	prewarmCmd.Flags().Float64Var(&flagReadyMinFreshRatio, "ready-min-fresh-ratio", 0.9, "Fraction of credentials that must have been prewarmed within their expiry for /readyz to report readiness.")
 	rootCmd.AddCommand(&prewarmCmd)
 
 	ctx := httpext.ContextWithSIGINT(context.Background(), 1*time.Second)
@@ -85,109 +151,314 @@ func main() {
 
 func runCheckKeystone(cmd *cobra.Command, args []string) {
 	creds := MustParseCredentials(args)
-	identityV3 := MustConnectToKeystone(cmd.Context())
+	kc := &KeystoneClient{IdentityV3: MustConnectToKeystone(cmd.Context())}
 
 	for _, cred := range creds {
-		printAsJSON(GetCredentialFromKeystone(cmd.Context(), identityV3, cred))
+		payload, err := GetCredentialFromKeystone(cmd.Context(), kc, cred)
+		switch {
+		case errors.Is(err, ErrNotFound), errors.Is(err, ErrUnauthorized), errors.Is(err, ErrForbidden):
+			// not being able to use the credential is a valid result for this check
+			logg.Error(err.Error())
+		case err != nil:
+			logg.Fatal(err.Error())
+		}
+		printAsJSON(payload)
 	}
 }
 
 func runCheckMemcache(cmd *cobra.Command, args []string) {
 	creds := MustParseCredentials(args)
-	mc := memcache.New(flagMemcacheServers...)
+	mustApplySwiftConfig(cmd)
+	mc := MustConnectToMemcache(mustResolveMemcacheServers(cmd.Context()), flagMemcache)
 
 	for _, cred := range creds {
-		printAsJSON(GetCredentialFromMemcache(mc, cred))
+		payload, err := GetCredentialFromMemcache(mc, cred)
+		if err != nil {
+			logg.Fatal(err.Error())
+		}
+		printAsJSON(payload)
 	}
 }
 
-var (
-	prewarmTimestampSecsGauge = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "swift_s3_cache_prewarm_last_run_secs",
-			Help: "UNIX timestamp in seconds of last successful cache prewarm for a particular S3 credential.",
-		},
-		[]string{"userid", "accesskey"},
-	)
-	prewarmDurationSecsGauge = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: "swift_s3_cache_prewarm_duration_secs",
-			Help: "Duration in seconds of last successful cache prewarm for a particular S3 credential.",
-		},
-		[]string{"userid", "accesskey"},
-	)
-)
+func runDiff(cmd *cobra.Command, args []string) {
+	creds := MustParseCredentials(args)
+	mustApplySwiftConfig(cmd)
+	kc := &KeystoneClient{IdentityV3: MustConnectToKeystone(cmd.Context())}
+	mc := MustConnectToMemcache(mustResolveMemcacheServers(cmd.Context()), flagMemcache)
+
+	diffs := make([]CredentialDiff, len(creds))
+	for idx, cred := range creds {
+		var err error
+		diffs[idx], err = DiffCredential(cmd.Context(), kc, mc, cred)
+		if err != nil {
+			logg.Fatal(err.Error())
+		}
+	}
+
+	if flagDiffJSON {
+		printAsJSON(diffs)
+	} else {
+		PrintDiffs(os.Stdout, diffs)
+	}
+	os.Exit(ExitCodeForDiffs(diffs))
+}
 
 func runPrewarm(cmd *cobra.Command, args []string) {
 	ctx := cmd.Context()
-	creds := MustParseCredentials(args)
-	identityV3 := MustConnectToKeystone(ctx)
-	mc := memcache.New(flagMemcacheServers...)
+	source := credentialSource{
+		ConfigPath:      flagConfigPath,
+		CredentialsPath: flagCredentialsPath,
+		Args:            MustParseCredentials(args),
+	}
+	fileContents := readFiles(source.files())
+	cfg, credConfigs, err := source.Load()
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	if len(cfg.SwiftConfigPaths) > 0 && !cmd.Flags().Changed("swift-config") {
+		flagSwiftConfigPaths = cfg.SwiftConfigPaths
+	}
+	swiftCfg := mustApplySwiftConfig(cmd)
+	ApplyConfigToFlags(cmd, cfg)
+
+	err = validateExpiry(flagExpiryTime)
+	if err != nil {
+		logg.Fatal("invalid value for --expiry: %s", err.Error())
+	}
+	if swiftCfg.SecretCacheDuration != nil {
+		switch duration := *swiftCfg.SecretCacheDuration; {
+		case duration == 0:
+			logg.Error("WARNING: secret_cache_duration of the s3token middleware is 0, so Swift does not use the cache entries written by the prewarmer")
+		case duration != flagExpiryTime:
+			logg.Error("WARNING: --expiry is %s, but secret_cache_duration of the s3token middleware is %s, so cache entries written by Swift and by the prewarmer have different lifetimes",
+				flagExpiryTime, duration)
+		}
+	}
+	if flagMaxStaleness < 0 {
+		logg.Fatal("invalid value for --max-staleness: must not be negative")
+	}
+	if flagKeystoneRateLimit < 0 {
+		logg.Fatal("invalid value for --keystone-rate-limit: must not be negative")
+	}
+	if flagKeystoneBurst < 1 {
+		logg.Fatal("invalid value for --keystone-burst: must be at least 1")
+	}
+	if flagKeystoneBreakerThreshold < 0 {
+		logg.Fatal("invalid value for --keystone-breaker-threshold: must not be negative")
+	}
+	if flagKeystoneBreakerBackoff <= 0 {
+		logg.Fatal("invalid value for --keystone-breaker-backoff: must be positive")
+	}
+	if flagKeystoneSecretRevalidationInterval < 0 {
+		logg.Fatal("invalid value for --keystone-secret-revalidation-interval: must not be negative")
+	}
+	if flagMemcache.Replicas < 1 {
+		logg.Fatal("invalid value for --memcache-replicas: must be at least 1")
+	}
+	if flagMemcacheDNSInterval <= 0 {
+		logg.Fatal("invalid value for --memcache-dns-interval: must be positive")
+	}
+	if flagMemcacheHealthInterval < 0 {
+		logg.Fatal("invalid value for --memcache-health-interval: must not be negative")
+	}
+	if flagReadyMinFreshRatio < 0 || flagReadyMinFreshRatio > 1 {
+		logg.Fatal("invalid value for --ready-min-fresh-ratio: must be between 0 and 1")
+	}
+	if flagConcurrency < 1 {
+		logg.Fatal("invalid value for --concurrency: must be at least 1")
+	}
+	if flagRequestTimeout <= 0 {
+		logg.Fatal("invalid value for --request-timeout: must be positive")
+	}
+	selectors, err := ParseDiscoverySelectors(flagDiscoverySelectors)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	if len(credConfigs) == 0 && len(selectors) == 0 {
+		logg.Fatal("no credentials to prewarm: give at least one userid:accesskey pair or --discover selector, either on the command line or in the config file or credentials file")
+	}
+
+	var adminToken string
+	if flagAdminTokenFile != "" {
+		buf, err := os.ReadFile(flagAdminTokenFile)
+		if err != nil {
+			logg.Fatal("cannot read --admin-token-file: %s", err.Error())
+		}
+		adminToken = strings.TrimSpace(string(buf))
+		if adminToken == "" {
+			logg.Fatal("invalid value for --admin-token-file: %s is empty", flagAdminTokenFile)
+		}
+	}
+
+	keystone := &KeystoneClient{IdentityV3: MustConnectToKeystone(ctx)}
+	if flagKeystoneRateLimit > 0 {
+		keystone.RateLimiter = NewRateLimiter(flagKeystoneRateLimit, flagKeystoneBurst)
+	}
+	if flagKeystoneBreakerThreshold > 0 {
+		keystone.CircuitBreaker = NewCircuitBreaker(flagKeystoneBreakerThreshold, flagKeystoneBreakerBackoff, keystoneBreakerMaxBackoff)
+	}
+	if flagKeystoneSecretRevalidationInterval > 0 {
+		keystone.SecretCache = NewSecretCache(flagKeystoneSecretRevalidationInterval)
+	}
+
+	p := &Prewarmer{
+		Keystone:     keystone,
+		Memcache:     MustConnectToMemcache(mustResolveMemcacheServers(ctx), flagMemcache),
+		Conservative: flagConservative,
+		Expiry:       flagExpiryTime,
+		Evict:        flagEvict,
+		MaxStaleness: flagMaxStaleness,
+
+		Concurrency:    flagConcurrency,
+		RequestTimeout: flagRequestTimeout,
+	}
+	if flagDryRun {
+		runPrewarmDryRun(ctx, p, credConfigs, selectors)
+		return
+	}
+	if flagOnce {
+		runPrewarmOnce(ctx, p, credConfigs, selectors)
+		return
+	}
 
 	// expose Prometheus metrics
 	prometheus.MustRegister(prewarmTimestampSecsGauge)
 	prometheus.MustRegister(prewarmDurationSecsGauge)
+	prometheus.MustRegister(refreshesCounter)
+	prometheus.MustRegister(casConflictsCounter)
+	prometheus.MustRegister(nextRefreshSecsGauge)
+	prometheus.MustRegister(staleCredentialsGauge)
+	prometheus.MustRegister(staleSecsGauge)
+	prometheus.MustRegister(overrunCyclesCounter)
+	prometheus.MustRegister(cycleDurationSecsHistogram)
+	prometheus.MustRegister(attemptsCounter)
+	prometheus.MustRegister(failuresCounter)
+	prometheus.MustRegister(memcacheReplicaWritesCounter)
+	prometheus.MustRegister(memcacheCallDurationSecsHistogram)
+	prometheus.MustRegister(memcacheUpGauge)
+	prometheus.MustRegister(memcacheLatencySecsGauge)
+	prometheus.MustRegister(memcacheEvictionsGauge)
+	prometheus.MustRegister(memcacheCurrItemsGauge)
+	prometheus.MustRegister(keystoneCallsCounter)
+	prometheus.MustRegister(keystoneCallDurationSecsHistogram)
+	prometheus.MustRegister(keystoneThrottledCallsCounter)
+	prometheus.MustRegister(keystoneRejectedCallsCounter)
+	prometheus.MustRegister(keystoneBreakerStateGauge)
+	prometheus.MustRegister(evictionsCounter)
+	prometheus.MustRegister(credentialLabelGauge)
+	var memcacheHealth *memcacheHealthChecker
+	if flagMemcacheHealthInterval > 0 {
+		memcacheHealth = &memcacheHealthChecker{Memcache: p.Memcache}
+	}
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", promhttp.Handler())
+	HealthHandler{
+		Prewarmer:      p,
+		MemcacheHealth: memcacheHealth,
+		MinFreshRatio:  flagReadyMinFreshRatio,
+		StartedAt:      time.Now(),
+	}.AddTo(mux)
+	// credentials to be prewarmed immediately on request of the admin API
+	triggered := make(chan []CredentialID, 16)
+	if adminToken != "" {
+		AdminAPI{Prewarmer: p, Token: adminToken, Trigger: triggered}.AddTo(mux)
+	}
 	go func() {
 		must.Succeed(httpext.ListenAndServeContext(ctx, flagPromListenAddress, mux))
 	}()
-	// make sure that all swift_s3_cache_prewarm_last_run_secs timeseries exist,
-	// even if prewarm never succeeds
-	for _, cred := range creds {
-		prewarmTimestampSecsGauge.With(cred.AsLabels()).Set(0)
+
+	p.SetConfiguredCredentials(credConfigs)
+	if len(selectors) > 0 {
+		mustDiscoverCredentials(ctx, p, selectors)
+		go runDiscoveryLoop(ctx, p, selectors, flagDiscoveryInterval)
 	}
 
-	cycleLength := flagExpiryTime / 5
-	tick := time.Tick(cycleLength)
+	reloaded := make(chan []CredentialID)
+	go runReloadLoop(ctx, p, source, fileContents, configPollInterval, reloaded)
+	if memcacheHealth != nil {
+		go runMemcacheHealthLoop(ctx, memcacheHealth, flagMemcacheHealthInterval)
+	}
+	moved := make(chan []CredentialID)
+	if hasDNSServerSpecs(flagMemcacheServers) {
+		go runMemcacheDNSLoop(ctx, p, net.DefaultResolver, flagMemcacheServers, flagMemcacheDNSInterval, moved)
+	}
 
-	// do the first prewarm immediately
-	doPrewarmCycle(ctx, creds, identityV3, mc)
+	runPrewarmLoop(ctx, p, reloaded, moved, triggered)
+}
+
+// runPrewarmOnce implements `prewarm --once`.
+func runPrewarmOnce(ctx context.Context, p *Prewarmer, credConfigs []CredentialConfig, selectors []DiscoverySelector) {
+	p.SetConfiguredCredentials(credConfigs)
+	if len(selectors) > 0 {
+		mustDiscoverCredentials(ctx, p, selectors)
+	}
+	p.doPrewarmCycle(ctx, 0)
 
-	for {
-		select {
-		case <-ctx.Done():
-			// exit if SIGINT was received
-			return
-		case <-tick:
-			doPrewarmCycle(ctx, creds, identityV3, mc)
+	results := p.Results()
+	printAsJSON(results)
+	failed := 0
+	for _, result := range results {
+		if !result.Success {
+			failed++
 		}
 	}
+	if failed > 0 {
+		logg.Fatal("%d of %d credentials could not be prewarmed", failed, len(results))
+	}
 }
 
-func doPrewarmCycle(ctx context.Context, creds []CredentialID, identityV3 *gophercloud.ServiceClient, mc *memcache.Client) {
-	for _, cred := range creds {
-		prewarmStart := time.Now()
-
-		// get new payload from Keystone
-		payload := GetCredentialFromKeystone(ctx, identityV3, cred)
-		if payload == nil {
-			// there was a problem getting the payload - we already logged the
-			// reason and can directly move on
-			continue
-		}
+// runPrewarmDryRun implements `prewarm --dry-run`.
+func runPrewarmDryRun(ctx context.Context, p *Prewarmer, credConfigs []CredentialConfig, selectors []DiscoverySelector) {
+	p.SetConfiguredCredentials(credConfigs)
+	if len(selectors) > 0 {
+		mustDiscoverCredentials(ctx, p, selectors)
+	}
 
-		// double-check with Memcache if requested
-		if flagConservative {
-			cachedPayload := GetCredentialFromMemcache(mc, cred)
-			// Accept a not yet cached credential in conservative mode to get it into the cache
-			if cachedPayload != nil && !cachedPayload.EqualTo(payload) {
-				logg.Info("skipping credential %q: payload in Memcache does not match our expectation", cred.String())
-				continue
-			}
+	plans := p.Plan(ctx)
+	printAsJSON(plans)
+	failing := 0
+	for _, plan := range plans {
+		// like with --once, a conflict in conservative mode counts as a failure
+		if plan.Action == planActionError || plan.Action == planActionSkipConflict {
+			failing++
 		}
+	}
+	if failing > 0 {
+		logg.Fatal("prewarming %d of %d credentials would fail", failing, len(plans))
+	}
+}
 
-		// write payload into Memcache (or, if the payload has not changed, just
-		// update the expiration time)
-		SetCredentialInMemcache(mc, cred, *payload, flagExpiryTime)
-		logg.Info("credential %q was prewarmed", cred.String())
+// mustDiscoverCredentials runs the initial Keystone discovery.
+func mustDiscoverCredentials(ctx context.Context, p *Prewarmer, selectors []DiscoverySelector) {
+	discoveredCreds, err := DiscoverCredentials(ctx, p.Keystone.IdentityV3, selectors)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	p.SetDiscoveredCredentials(discoveredCreds)
+}
 
-		// report Prometheus metrics for this prewarm run
-		prewarmEnd := time.Now()
-		labels := cred.AsLabels()
-		prewarmTimestampSecsGauge.With(labels).Set(float64(prewarmEnd.Unix()))
-		prewarmDurationSecsGauge.With(labels).Set(float64(prewarmEnd.Sub(prewarmStart)) / float64(time.Second))
+// mustResolveMemcacheServers resolves the entries in --servers that refer to
+// DNS records (if any).
+func mustResolveMemcacheServers(ctx context.Context) []string {
+	servers, err := resolveMemcacheServers(ctx, net.DefaultResolver, flagMemcacheServers)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	return servers
+}
+
+// mustApplySwiftConfig loads the config files given with --swift-config (if
+// any) and uses their contents as defaults for the respective flags.
+func mustApplySwiftConfig(cmd *cobra.Command) SwiftConfig {
+	if len(flagSwiftConfigPaths) == 0 {
+		return SwiftConfig{}
+	}
+	cfg, err := LoadSwiftConfig(flagSwiftConfigPaths)
+	if err != nil {
+		logg.Fatal(err.Error())
 	}
+	ApplySwiftConfigToFlags(cmd, cfg)
+	return cfg
 }
 
 func printAsJSON(val any) {
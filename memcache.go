@@ -4,37 +4,442 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
 )
 
+var memcacheReplicaWritesCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "swift_s3_cache_prewarm_memcache_replica_writes_total",
+		Help: `Counts writes to individual memcached servers when writing to multiple servers (see --memcache-replicas), with result "success" or "failure".`,
+	},
+	[]string{"server", "result"},
+)
+
+var memcacheCallDurationSecsHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "swift_s3_cache_prewarm_memcache_call_duration_secs",
+		Help:    `Duration in seconds of operations on cache entries in Memcache (including failover and replicas), by operation ("get", "set", "add", "cas", "delete" or "touch").`,
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 8),
+	},
+	[]string{"operation"},
+)
+
+// observeMemcacheCall is called with defer at the start of each operation.
+func observeMemcacheCall(operation string, start time.Time) {
+	memcacheCallDurationSecsHistogram.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// MemcacheClient is a memcache.Client that selects servers in the same way as Swift.
+type MemcacheClient struct {
+	*memcache.Client
+	Ring *MemcacheRing
+	// If greater than 1, writes go to this many servers (see onReplicas).
+	Replicas int
+	// clients that talk to one specific server each (key = address)
+	serverClients *sync.Map
+}
+
+// MemcacheTLSConfig contains the options for connecting to memcached with
+// TLS. They correspond to the "tls_*" options in Swift's memcache.conf.
+type MemcacheTLSConfig struct {
+	Enabled  bool
+	CAFile   string // if empty, the system's trusted CAs are used
+	CertFile string // client certificate (optional, requires KeyFile)
+	KeyFile  string
+	// If empty, the server certificate is verified against the hostname from
+	// the server string.
+	ServerName string
+}
+
+// Validate checks for inconsistent options, but does not read any files.
+func (c MemcacheTLSConfig) Validate() error {
+	switch {
+	case !c.Enabled && c != (MemcacheTLSConfig{}):
+		return errors.New("TLS options for memcached are given, but TLS is not enabled")
+	case (c.CertFile == "") != (c.KeyFile == ""):
+		return errors.New("client certificate and private key for memcached must be given together")
+	default:
+		return nil
+	}
+}
+
+// ClientConfig builds the configuration for crypto/tls from these options.
+// Returns (nil, nil) if TLS is not enabled.
+func (c MemcacheTLSConfig) ClientConfig() (*tls.Config, error) {
+	err := c.Validate()
+	if err != nil || !c.Enabled {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+	if c.CAFile != "" {
+		buf, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(buf) {
+			return nil, fmt.Errorf("no certificates found in %s", c.CAFile)
+		}
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// MemcacheOptions contains the options for connecting to memcached. Zero
+// values select the defaults of gomemcache (for timeouts) or Swift (for tries).
+type MemcacheOptions struct {
+	TLS            MemcacheTLSConfig
+	ConnectTimeout time.Duration
+	IOTimeout      time.Duration
+	// Same meaning as Swift's "tries" option in memcache.conf.
+	Tries int
+	// Number of servers that cache entries are written to (at most Tries).
+	Replicas int
+}
+
+// NewMemcacheClient prepares a Memcache client for the given servers.
+// (No connections are established until the first request is made.)
+func NewMemcacheClient(servers []string, opts MemcacheOptions) (MemcacheClient, error) {
+	if opts.ConnectTimeout < 0 || opts.IOTimeout < 0 || opts.Tries < 0 || opts.Replicas < 0 {
+		return MemcacheClient{}, errors.New("timeouts, number of tries and number of replicas for memcached must not be negative")
+	}
+	ring := NewMemcacheRing(opts.Tries)
+	err := ring.SetServers(servers...)
+	if err != nil {
+		return MemcacheClient{}, fmt.Errorf("cannot parse list of memcached servers: %w", err)
+	}
+	tlsConfig, err := opts.TLS.ClientConfig()
+	if err != nil {
+		return MemcacheClient{}, fmt.Errorf("cannot load TLS configuration for memcached: %w", err)
+	}
+
+	client := memcache.NewFromSelector(ring)
+	client.Timeout = opts.IOTimeout
+	// gomemcache uses the same timeout for connecting and for I/O, so we need
+	// our own dialer if there is a separate connect timeout
+	netDialer := &net.Dialer{Timeout: opts.ConnectTimeout}
+	switch {
+	case tlsConfig != nil:
+		dialer := &tls.Dialer{NetDialer: netDialer, Config: tlsConfig}
+		client.DialContext = dialer.DialContext
+	case opts.ConnectTimeout != 0:
+		client.DialContext = netDialer.DialContext
+	}
+	return MemcacheClient{
+		Client:        client,
+		Ring:          ring,
+		Replicas:      opts.Replicas,
+		serverClients: &sync.Map{},
+	}, nil
+}
+
+// MustConnectToMemcache is like NewMemcacheClient, but dies on error.
+func MustConnectToMemcache(servers []string, opts MemcacheOptions) MemcacheClient {
+	mc, err := NewMemcacheClient(servers, opts)
+	if err != nil {
+		logg.Fatal(err.Error())
+	}
+	return mc
+}
+
+// withFailover executes an action concerning the given key. If the server
+// selected for this key fails, the action is retried on the next server, like
+// Swift's MemcacheRing would do.
+func (mc MemcacheClient) withFailover(key string, action func(*memcache.Client) error) error {
+	addrs := mc.Ring.FailoverOrder(key)
+	if len(addrs) == 0 {
+		return memcache.ErrNoServers
+	}
+	var err error
+	for _, addr := range addrs {
+		err = action(mc.clientForServer(addr))
+		if !isMemcacheServerFailure(err) {
+			return err
+		}
+		mc.Ring.ErrorOccurred(addr)
+	}
+	return err
+}
+
+// onReplicas executes an action on each of the first mc.Replicas servers
+// that Swift would try for the given key, so that Swift still finds the cache
+// entry after failing over from a server that is down. Returns the result of
+// the action for each server.
+func (mc MemcacheClient) onReplicas(key string, action func(*memcache.Client) error) []error {
+	addrs := mc.Ring.FailoverOrder(key)
+	if len(addrs) == 0 {
+		return []error{memcache.ErrNoServers}
+	}
+	addrs = addrs[:min(len(addrs), mc.Replicas)]
+
+	errs := make([]error, len(addrs))
+	for idx, addr := range addrs {
+		err := action(mc.clientForServer(addr))
+		if isMemcacheServerFailure(err) {
+			mc.Ring.ErrorOccurred(addr)
+		}
+		result := "success"
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			result = "failure"
+		}
+		memcacheReplicaWritesCounter.WithLabelValues(addr.String(), result).Inc()
+		errs[idx] = err
+	}
+	return errs
+}
+
+// clientForServer returns a memcache.Client that only talks to the given server.
+func (mc MemcacheClient) clientForServer(addr net.Addr) *memcache.Client {
+	if client, ok := mc.serverClients.Load(addr.String()); ok {
+		return client.(*memcache.Client) //nolint:errcheck // only this function stores into the map
+	}
+	client := memcache.NewFromSelector(singleServerSelector{addr})
+	client.Timeout = mc.Timeout
+	client.DialContext = mc.DialContext
+	actual, _ := mc.serverClients.LoadOrStore(addr.String(), client)
+	return actual.(*memcache.Client) //nolint:errcheck // see above
+}
+
+// singleServerSelector is a memcache.ServerSelector that always selects the same server.
+type singleServerSelector struct {
+	addr net.Addr
+}
+
+func (s singleServerSelector) PickServer(string) (net.Addr, error)    { return s.addr, nil }
+func (s singleServerSelector) Each(action func(net.Addr) error) error { return action(s.addr) }
+
+// combineReplicaErrors converts the results of onReplicas() into the result
+// of the overall operation: It succeeds if the action succeeded on at least
+// one server. Otherwise, a cache miss is reported if at least one server
+// reported a cache miss, or else the error of the first server is returned.
+func combineReplicaErrors(errs []error) error {
+	if slices.Contains(errs, nil) {
+		return nil
+	}
+	for _, err := range errs {
+		if errors.Is(err, memcache.ErrCacheMiss) {
+			return err
+		}
+	}
+	return errs[0]
+}
+
 // GetCredentialFromMemcache fetches an EC2 credential from Memcache.
-// Returns nil if the credential does not exist.
-func GetCredentialFromMemcache(mc *memcache.Client, cred CredentialID) *CredentialPayload {
-	item, err := mc.Get(cred.CacheKey())
+// Returns (nil, nil) if the credential does not exist.
+func GetCredentialFromMemcache(mc MemcacheClient, cred CredentialID) (*CredentialPayload, error) {
+	cached, err := GetCachedCredentialFromMemcache(mc, cred)
+	if cached == nil || err != nil {
+		return nil, err
+	}
+	return cached.Payload, nil
+}
+
+// CachedCredential is a credential payload that was read from Memcache,
+// together with the information that CompareAndSwapCredentialInMemcache()
+// needs to replace exactly this cache entry.
+type CachedCredential struct {
+	Credential CredentialID
+	Payload    *CredentialPayload
+	item       *memcache.Item
+}
+
+// GetCachedCredentialFromMemcache is like GetCredentialFromMemcache, but
+// allows for the cache entry to be replaced with
+// CompareAndSwapCredentialInMemcache() afterwards.
+// Returns (nil, nil) if the credential does not exist.
+func GetCachedCredentialFromMemcache(mc MemcacheClient, cred CredentialID) (*CachedCredential, error) {
+	defer observeMemcacheCall("get", time.Now())
+	var item *memcache.Item
+	err := mc.withFailover(cred.CacheKey(), func(c *memcache.Client) (err error) {
+		item, err = c.Get(cred.CacheKey())
+		return err
+	})
 	if errors.Is(err, memcache.ErrCacheMiss) {
-		return nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, memcacheError(cred, "fetch credential from Memcache", err)
 	}
-	mustDo("fetch credential from Memcache", err)
 
 	var payload CredentialPayload
-	mustDo("decode credential payload from Memcache", json.Unmarshal(item.Value, &payload))
-	return &payload
+	err = json.Unmarshal(item.Value, &payload)
+	if err != nil {
+		return nil, decodeError(cred, "decode credential payload from Memcache", err)
+	}
+	return &CachedCredential{Credential: cred, Payload: &payload, item: item}, nil
 }
 
-// SetCredentialInMemcache writes an EC2 credential into Memcache.
-func SetCredentialInMemcache(mc *memcache.Client, cred CredentialID, payload CredentialPayload, expiry time.Duration) {
-	buf, err := json.Marshal(payload)
-	mustDo("encode credential payload for Memcache", err)
+// SetCredentialInMemcache writes an EC2 credential into Memcache. If
+// mc.Replicas is greater than 1, the credential is written to that many
+// servers, and the write counts as successful if at least one of them succeeded.
+func SetCredentialInMemcache(mc MemcacheClient, cred CredentialID, payload CredentialPayload, expiry time.Duration) error {
+	defer observeMemcacheCall("set", time.Now())
+	item, err := newCredentialItem(cred, payload, expiry)
+	if err != nil {
+		return err
+	}
+	if mc.Replicas > 1 {
+		err = combineReplicaErrors(mc.onReplicas(item.Key, func(c *memcache.Client) error { return c.Set(item) }))
+	} else {
+		err = mc.withFailover(item.Key, func(c *memcache.Client) error { return c.Set(item) })
+	}
+	if err != nil {
+		return memcacheError(cred, "save credential payload in Memcache", err)
+	}
+	return nil
+}
+
+// AddCredentialToMemcache is like SetCredentialInMemcache, but only writes
+// the credential if it does not exist in Memcache yet. Otherwise, an error
+// wrapping memcache.ErrNotStored is returned.
+func AddCredentialToMemcache(mc MemcacheClient, cred CredentialID, payload CredentialPayload, expiry time.Duration) error {
+	defer observeMemcacheCall("add", time.Now())
+	item, err := newCredentialItem(cred, payload, expiry)
+	if err != nil {
+		return err
+	}
+	err = mc.withFailover(item.Key, func(c *memcache.Client) error { return c.Add(item) })
+	if err != nil {
+		return memcacheError(cred, "add credential payload to Memcache", err)
+	}
+	return nil
+}
+
+// CompareAndSwapCredentialInMemcache replaces a cache entry that was
+// previously read with GetCachedCredentialFromMemcache(), but only if it was
+// not changed (error wrapping memcache.ErrCASConflict) or deleted or expired
+// (error wrapping memcache.ErrCacheMiss) in the meantime.
+func CompareAndSwapCredentialInMemcache(mc MemcacheClient, cached CachedCredential, payload CredentialPayload, expiry time.Duration) error {
+	defer observeMemcacheCall("cas", time.Now())
+	cred := cached.Credential
+	item, err := newCredentialItem(cred, payload, expiry)
+	if err != nil {
+		return err
+	}
+	// reuse the item that we got from memcache (it contains the CAS ID)
+	casItem := *cached.item
+	casItem.Value = item.Value
+	casItem.Flags = item.Flags
+	casItem.Expiration = item.Expiration
+	err = mc.withFailover(item.Key, func(c *memcache.Client) error { return c.CompareAndSwap(&casItem) })
+	if err != nil {
+		return memcacheError(cred, "replace credential payload in Memcache", err)
+	}
+	return nil
+}
 
-	err = mc.Set(&memcache.Item{
+func newCredentialItem(cred CredentialID, payload CredentialPayload, expiry time.Duration) (*memcache.Item, error) {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return nil, CredentialError{Credential: cred, Action: "encode credential payload for Memcache", Inner: err}
+	}
+	return &memcache.Item{
 		Key:        cred.CacheKey(),
 		Value:      buf,
 		Flags:      2, // indicates data type JSON within Swift
 		Expiration: int32(expiry.Seconds()),
-	})
-	mustDo("save credential payload in Memcache", err)
+	}, nil
+}
+
+// TouchOrRewriteReplicasInMemcache is used instead of
+// TouchCredentialInMemcache if mc.Replicas is greater than 1. Each replica is
+// inspected separately: If it contains the given payload, only its expiration
+// time is updated. Otherwise (e.g. because the server was restarted, or missed
+// an earlier rewrite while it was down), the payload is written into it.
+// Returns whether the payload was written into any replica.
+func TouchOrRewriteReplicasInMemcache(mc MemcacheClient, cred CredentialID, payload CredentialPayload, expiry time.Duration) (rewritten bool, err error) {
+	defer observeMemcacheCall("touch", time.Now())
+	item, err := newCredentialItem(cred, payload, expiry)
+	if err != nil {
+		return false, err
+	}
+	err = combineReplicaErrors(mc.onReplicas(item.Key, func(c *memcache.Client) error {
+		cachedItem, err := c.Get(item.Key)
+		if err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+			return err
+		}
+		if err == nil && replicaContains(cachedItem, payload) {
+			err = c.Touch(item.Key, item.Expiration)
+			if !errors.Is(err, memcache.ErrCacheMiss) {
+				return err
+			}
+			// otherwise the replica has expired or was evicted since we read it
+		}
+		rewritten = true
+		return c.Set(item)
+	}))
+	if err != nil {
+		return false, memcacheError(cred, "update expiration time of credential payload in Memcache", err)
+	}
+	return rewritten, nil
+}
+
+func replicaContains(item *memcache.Item, payload CredentialPayload) bool {
+	var cached CredentialPayload
+	err := json.Unmarshal(item.Value, &cached)
+	return err == nil && cached.EqualTo(&payload)
+}
+
+// DeleteCredentialFromMemcache removes an EC2 credential from Memcache (from
+// all replicas, if mc.Replicas is greater than 1).
+// Returns false if the credential was not cached in the first place.
+func DeleteCredentialFromMemcache(mc MemcacheClient, cred CredentialID) (bool, error) {
+	defer observeMemcacheCall("delete", time.Now())
+	var err error
+	if mc.Replicas > 1 {
+		err = combineReplicaErrors(mc.onReplicas(cred.CacheKey(), func(c *memcache.Client) error { return c.Delete(cred.CacheKey()) }))
+	} else {
+		err = mc.withFailover(cred.CacheKey(), func(c *memcache.Client) error { return c.Delete(cred.CacheKey()) })
+	}
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return false, nil
+	}
+	if err != nil {
+		return false, memcacheError(cred, "delete credential payload from Memcache", err)
+	}
+	return true, nil
+}
+
+// TouchCredentialInMemcache updates the expiration time of an EC2 credential
+// in Memcache without changing its payload (on all replicas, if mc.Replicas
+// is greater than 1).
+// Returns false if the credential was not cached in the first place.
+func TouchCredentialInMemcache(mc MemcacheClient, cred CredentialID, expiry time.Duration) (bool, error) {
+	defer observeMemcacheCall("touch", time.Now())
+	var err error
+	if mc.Replicas > 1 {
+		err = combineReplicaErrors(mc.onReplicas(cred.CacheKey(), func(c *memcache.Client) error { return c.Touch(cred.CacheKey(), int32(expiry.Seconds())) }))
+	} else {
+		err = mc.withFailover(cred.CacheKey(), func(c *memcache.Client) error { return c.Touch(cred.CacheKey(), int32(expiry.Seconds())) })
+	}
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return false, nil
+	}
+	if err != nil {
+		return false, memcacheError(cred, "update expiration time of credential payload in Memcache", err)
+	}
+	return true, nil
 }
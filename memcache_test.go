@@ -0,0 +1,441 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMemcached implements the parts of the memcached text protocol that are
+// used by gomemcache.
+type fakeMemcached struct {
+	Listener net.Listener
+	mutex    sync.Mutex
+	items    map[string]fakeMemcachedItem
+	nextCAS  uint64
+	// key = "add" or "cas", value = how many of the next such commands shall
+	// behave as if another client wrote into the item just before
+	interferences map[string]int
+	// like interferences, but as if another client deleted the item just before
+	deletions map[string]int
+}
+
+type fakeMemcachedItem struct {
+	Value      []byte
+	Flags      uint32
+	Expiration int32
+	CAS        uint64
+}
+
+func newFakeMemcached(t *testing.T) *fakeMemcached {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return startFakeMemcached(t, listener)
+}
+
+func startFakeMemcached(t *testing.T, listener net.Listener) *fakeMemcached {
+	t.Helper()
+	fm := &fakeMemcached{
+		Listener: listener,
+		items:    make(map[string]fakeMemcachedItem),
+
+		interferences: make(map[string]int),
+		deletions:     make(map[string]int),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go fm.serve(conn)
+		}
+	}()
+	return fm
+}
+
+// Address returns the address of this server in the form used by --servers.
+func (fm *fakeMemcached) Address() string {
+	return fm.Listener.Addr().String()
+}
+
+// Client returns a MemcacheClient that talks to only this server.
+func (fm *fakeMemcached) Client() MemcacheClient {
+	return MustConnectToMemcache([]string{fm.Address()}, MemcacheOptions{})
+}
+
+// Get returns the item with the given key.
+func (fm *fakeMemcached) Get(key string) (fakeMemcachedItem, bool) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	item, exists := fm.items[key]
+	return item, exists
+}
+
+// Set stores an item with the given key.
+func (fm *fakeMemcached) Set(key string, value []byte) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	fm.storeLocked(key, fakeMemcachedItem{Value: value})
+}
+
+// Flush removes all items, like when memcached is restarted.
+func (fm *fakeMemcached) Flush() {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	clear(fm.items)
+}
+
+// Interfere makes the next `count` commands with the given verb ("add" or
+// "cas") behave as if another client wrote into the item just before.
+func (fm *fakeMemcached) Interfere(verb string, count int) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	fm.interferences[verb] = count
+}
+
+// InterfereByDeleting makes the next `count` commands with the given verb
+// ("add" or "cas") behave as if another client deleted the item just before.
+func (fm *fakeMemcached) InterfereByDeleting(verb string, count int) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+	fm.deletions[verb] = count
+}
+
+func (fm *fakeMemcached) storeLocked(key string, item fakeMemcachedItem) {
+	fm.nextCAS++
+	item.CAS = fm.nextCAS
+	fm.items[key] = item
+}
+
+func (fm *fakeMemcached) serve(conn net.Conn) {
+	defer conn.Close()
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+		response, err := fm.handle(fields, rw.Reader)
+		if err != nil {
+			return
+		}
+		_, err = rw.WriteString(response)
+		if err == nil {
+			err = rw.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (fm *fakeMemcached) handle(fields []string, r *bufio.Reader) (string, error) {
+	fm.mutex.Lock()
+	defer fm.mutex.Unlock()
+
+	switch verb, args := fields[0], fields[1:]; verb {
+	case "version":
+		return "VERSION 1.6.0-fake\r\n", nil
+
+	case "gets", "gat", "gats":
+		if verb != "gets" {
+			if len(args) < 2 {
+				return "ERROR\r\n", nil
+			}
+			args = args[1:]
+		}
+		var sb strings.Builder
+		for _, key := range args {
+			item, exists := fm.items[key]
+			if exists {
+				fmt.Fprintf(&sb, "VALUE %s %d %d %d\r\n%s\r\n", key, item.Flags, len(item.Value), item.CAS, item.Value)
+			}
+		}
+		sb.WriteString("END\r\n")
+		return sb.String(), nil
+
+	case "set", "add", "cas":
+		if len(args) < 4 || (verb == "cas" && len(args) < 5) {
+			return "ERROR\r\n", nil
+		}
+		flags, err1 := strconv.ParseUint(args[1], 10, 32)
+		expiration, err2 := strconv.ParseInt(args[2], 10, 32)
+		size, err3 := strconv.Atoi(args[3])
+		if err := errors.Join(err1, err2, err3); err != nil {
+			return "CLIENT_ERROR bad command line format\r\n", nil
+		}
+		buf := make([]byte, size+2)
+		_, err := io.ReadFull(r, buf)
+		if err != nil {
+			return "", err
+		}
+		key := args[0]
+		if fm.deletions[verb] > 0 {
+			fm.deletions[verb]--
+			delete(fm.items, key)
+		}
+		if fm.interferences[verb] > 0 {
+			fm.interferences[verb]--
+			// another client writes the same value, but this still changes the CAS ID
+			fm.storeLocked(key, fakeMemcachedItem{Value: buf[:size], Flags: uint32(flags), Expiration: int32(expiration)})
+		}
+		existing, exists := fm.items[key]
+		switch {
+		case verb == "add" && exists:
+			return "NOT_STORED\r\n", nil
+		case verb == "cas" && !exists:
+			return "NOT_FOUND\r\n", nil
+		case verb == "cas" && args[4] != strconv.FormatUint(existing.CAS, 10):
+			return "EXISTS\r\n", nil
+		}
+		fm.storeLocked(key, fakeMemcachedItem{Value: buf[:size], Flags: uint32(flags), Expiration: int32(expiration)})
+		return "STORED\r\n", nil
+
+	case "stats":
+		if len(args) > 0 {
+			return "ERROR\r\n", nil
+		}
+		return fmt.Sprintf("STAT pid 1\r\nSTAT curr_items %d\r\nSTAT evictions 0\r\nEND\r\n", len(fm.items)), nil
+
+	case "touch":
+		if len(args) < 2 {
+			return "ERROR\r\n", nil
+		}
+		item, exists := fm.items[args[0]]
+		if !exists {
+			return "NOT_FOUND\r\n", nil
+		}
+		expiration, err := strconv.ParseInt(args[1], 10, 32)
+		if err != nil {
+			return "CLIENT_ERROR bad command line format\r\n", nil
+		}
+		item.Expiration = int32(expiration)
+		fm.items[args[0]] = item
+		return "TOUCHED\r\n", nil
+
+	case "delete":
+		if len(args) < 1 {
+			return "ERROR\r\n", nil
+		}
+		if _, exists := fm.items[args[0]]; !exists {
+			return "NOT_FOUND\r\n", nil
+		}
+		delete(fm.items, args[0])
+		return "DELETED\r\n", nil
+
+	default:
+		return "ERROR\r\n", nil
+	}
+}
+
+func TestMemcacheRoundtrip(t *testing.T) {
+	fm := newFakeMemcached(t)
+	mc := fm.Client()
+	cred := CredentialID{UserID: "uid1", AccessKey: "key1"}
+
+	payload, err := GetCredentialFromMemcache(mc, cred)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if payload != nil {
+		t.Errorf("expected cache miss, but got %#v", payload)
+	}
+
+	expected := CredentialPayload{Headers: map[string]string{"X-User-Id": "uid1"}, Secret: "secret"}
+	err = SetCredentialInMemcache(mc, cred, expected, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	item, _ := fm.Get(cred.CacheKey())
+	if item.Flags != 2 || item.Expiration != 600 {
+		t.Errorf("expected item to be stored with flags = 2 and expiration = 600, but got %d and %d", item.Flags, item.Expiration)
+	}
+	payload, err = GetCredentialFromMemcache(mc, cred)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !payload.EqualTo(&expected) {
+		t.Errorf("expected %#v, but got %#v", expected, payload)
+	}
+
+	for _, expectedDeleted := range []bool{true, false} {
+		deleted, err := DeleteCredentialFromMemcache(mc, cred)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if deleted != expectedDeleted {
+			t.Errorf("expected DeleteCredentialFromMemcache() to return %t, but got %t", expectedDeleted, deleted)
+		}
+	}
+}
+
+func TestMemcacheFailover(t *testing.T) {
+	fm := newFakeMemcached(t)
+	downServer := newFakeMemcached(t)
+	downServer.Listener.Close()
+	mc, err := NewMemcacheClient([]string{fm.Address(), downServer.Address()}, MemcacheOptions{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// find a credential whose cache entry belongs onto the server that is down
+	var cred CredentialID
+	for idx := 0; ; idx++ {
+		cred = CredentialID{UserID: "uid", AccessKey: fmt.Sprintf("key%d", idx)}
+		if mc.Ring.Placement(cred.CacheKey())[0] == downServer.Address() {
+			break
+		}
+	}
+
+	// each request fails over to the next server on its own...
+	payload := CredentialPayload{Headers: map[string]string{"X-User-Id": "uid"}, Secret: "secret"}
+	err = SetCredentialInMemcache(mc, cred, payload, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, exists := fm.Get(cred.CacheKey()); !exists {
+		t.Error("expected credential to be written to the failover server")
+	}
+
+	// ...so like in Swift, a single error does not take the server out of the ring
+	if first := mc.Ring.FailoverOrder(cred.CacheKey())[0]; first.String() != downServer.Address() {
+		t.Errorf("expected %s to not be error-limited after a single error, but failover order starts with %s", downServer.Address(), first.String())
+	}
+}
+
+func TestMemcacheTLS(t *testing.T) {
+	// setup a CA with a server certificate and a client certificate
+	dir := t.TempDir()
+	caCert, caKey := generateCertificate(t, filepath.Join(dir, "ca"), &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}, nil, nil)
+	generateCertificate(t, filepath.Join(dir, "server"), &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "memcached.example.com"},
+		DNSNames:    []string{"memcached.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+	generateCertificate(t, filepath.Join(dir, "client"), &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "prewarmer"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caCert, caKey)
+
+	// start a memcached stand-in that only accepts TLS connections with a client certificate
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server-key.pem"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fm := startFakeMemcached(t, listener)
+
+	cred := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	payload := CredentialPayload{Headers: map[string]string{"X-User-Id": "uid1"}, Secret: "secret"}
+	validOpts := MemcacheTLSConfig{
+		Enabled:    true,
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		CertFile:   filepath.Join(dir, "client.pem"),
+		KeyFile:    filepath.Join(dir, "client-key.pem"),
+		ServerName: "memcached.example.com",
+	}
+	testCases := []struct {
+		Description   string
+		Modify        func(opts *MemcacheTLSConfig)
+		ExpectSuccess bool
+	}{
+		{"valid options", func(opts *MemcacheTLSConfig) {}, true},
+		{"TLS disabled", func(opts *MemcacheTLSConfig) { *opts = MemcacheTLSConfig{} }, false},
+		{"server name does not match", func(opts *MemcacheTLSConfig) { opts.ServerName = "" }, false},
+		{"unknown CA", func(opts *MemcacheTLSConfig) { opts.CAFile = "" }, false},
+		{"no client certificate", func(opts *MemcacheTLSConfig) { opts.CertFile, opts.KeyFile = "", "" }, false},
+	}
+	for _, tc := range testCases {
+		opts := validOpts
+		tc.Modify(&opts)
+		mc, err := NewMemcacheClient([]string{fm.Address()}, MemcacheOptions{TLS: opts, IOTimeout: time.Second})
+		if err != nil {
+			t.Fatalf("%s: %s", tc.Description, err.Error())
+		}
+
+		err = SetCredentialInMemcache(mc, cred, payload, 10*time.Minute)
+		if tc.ExpectSuccess {
+			if err != nil {
+				t.Errorf("%s: expected write to succeed, but got: %s", tc.Description, err.Error())
+			} else if _, exists := fm.Get(cred.CacheKey()); !exists {
+				t.Errorf("%s: expected item to be written, but it does not exist", tc.Description)
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected write to fail, but it succeeded", tc.Description)
+		}
+	}
+}
+
+// generateCertificate writes a certificate and private key into "<path>.pem"
+// and "<path>-key.pem". If parent is nil, the certificate is self-signed.
+func generateCertificate(t *testing.T, path string, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	writeFile(t, path+".pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})))
+	writeFile(t, path+"-key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})))
+	return cert, key
+}
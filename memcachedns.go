@@ -0,0 +1,181 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+)
+
+// Entries in --servers with these prefixes are resolved via DNS instead of
+// being used as-is (see resolveMemcacheServers).
+const (
+	// "dns+srv://<name>" uses all targets of the SRV records for <name>.
+	dnsSRVServerPrefix = "dns+srv://"
+	// "dns://<host>[:<port>]" uses all addresses of <host>.
+	dnsServerPrefix = "dns://"
+)
+
+// dnsResolver contains the methods of net.Resolver that we use. This is an
+// interface so that it can be replaced in unit tests.
+type dnsResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// hasDNSServerSpecs checks whether any of the given server specs needs to be
+// resolved via DNS.
+func hasDNSServerSpecs(specs []string) bool {
+	return slices.ContainsFunc(specs, func(spec string) bool {
+		return strings.HasPrefix(spec, dnsSRVServerPrefix) || strings.HasPrefix(spec, dnsServerPrefix)
+	})
+}
+
+// validateMemcacheServerSpec checks the syntax of an entry in --servers.
+func validateMemcacheServerSpec(spec string) error {
+	if name, ok := strings.CutPrefix(spec, dnsSRVServerPrefix); ok {
+		if name == "" {
+			return fmt.Errorf("invalid memcached server %q: missing DNS name", spec)
+		}
+		return nil
+	}
+	if hostPort, ok := strings.CutPrefix(spec, dnsServerPrefix); ok {
+		_, err := parseMemcacheServer(hostPort)
+		return err
+	}
+	_, err := parseMemcacheServer(spec)
+	return err
+}
+
+// resolveMemcacheServers converts the entries in --servers into the list of
+// servers for the MemcacheRing. Entries that do not need to be resolved via
+// DNS are used unchanged.
+func resolveMemcacheServers(ctx context.Context, resolver dnsResolver, specs []string) ([]string, error) {
+	var result []string
+	for _, spec := range specs {
+		switch {
+		case strings.HasPrefix(spec, dnsSRVServerPrefix):
+			name := strings.TrimPrefix(spec, dnsSRVServerPrefix)
+			_, records, err := resolver.LookupSRV(ctx, "", "", name)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve memcached servers for %q: %w", spec, err)
+			}
+			for _, record := range records {
+				host := strings.TrimSuffix(record.Target, ".")
+				result = append(result, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+			}
+
+		case strings.HasPrefix(spec, dnsServerPrefix):
+			addr, err := parseMemcacheServer(strings.TrimPrefix(spec, dnsServerPrefix))
+			if err != nil {
+				return nil, err
+			}
+			host, port, err := net.SplitHostPort(addr.String())
+			if err != nil {
+				return nil, err
+			}
+			ips, err := resolver.LookupHost(ctx, host)
+			if err != nil {
+				return nil, fmt.Errorf("cannot resolve memcached servers for %q: %w", spec, err)
+			}
+			for _, ip := range ips {
+				result = append(result, net.JoinHostPort(ip, port))
+			}
+
+		default:
+			result = append(result, spec)
+		}
+	}
+	if len(result) == 0 {
+		return nil, errors.New("no memcached servers found")
+	}
+	return result, nil
+}
+
+// updateMemcacheServers replaces the servers in the MemcacheRing of this
+// Prewarmer, and returns the credentials whose placement in the ring changed
+// as a result.
+func updateMemcacheServers(p *Prewarmer, servers []string) (moved []CredentialID, err error) {
+	ring := p.Memcache.Ring
+	oldServers := ring.Servers()
+	newServers := slices.Clone(servers)
+	slices.Sort(newServers)
+	newServers = slices.Compact(newServers)
+	if slices.Equal(oldServers, newServers) {
+		return nil, nil
+	}
+
+	creds := p.Credentials()
+	oldPlacements := make([][]string, len(creds))
+	for idx, cred := range creds {
+		oldPlacements[idx] = ring.Placement(cred.CacheKey())
+	}
+	err = ring.SetServers(newServers...)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range newServers {
+		if !slices.Contains(oldServers, server) {
+			logg.Info("memcached server %s was added", server)
+		}
+	}
+	for _, server := range oldServers {
+		if !slices.Contains(newServers, server) {
+			logg.Info("memcached server %s was removed", server)
+			if addr, err := parseMemcacheServer(server); err == nil {
+				memcacheReplicaWritesCounter.DeletePartialMatch(prometheus.Labels{"server": addr.String()})
+			}
+		}
+	}
+
+	for idx, cred := range creds {
+		if !slices.Equal(oldPlacements[idx], ring.Placement(cred.CacheKey())) {
+			moved = append(moved, cred)
+		}
+	}
+	return moved, nil
+}
+
+// runMemcacheDNSLoop resolves the memcached servers in regular intervals and
+// updates the MemcacheRing accordingly. Credentials whose placement in the
+// ring changed are sent into the `moved` channel, so that they can be
+// prewarmed on their new servers right away.
+func runMemcacheDNSLoop(ctx context.Context, p *Prewarmer, resolver dnsResolver, specs []string, interval time.Duration, moved chan<- []CredentialID) {
+	tick := time.Tick(interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			servers, err := resolveMemcacheServers(ctx, resolver, specs)
+			if err != nil {
+				// keep using the servers that we know about
+				logg.Error(err.Error())
+				continue
+			}
+			movedCreds, err := updateMemcacheServers(p, servers)
+			if err != nil {
+				logg.Error("cannot update list of memcached servers: %s", err.Error())
+				continue
+			}
+			if len(movedCreds) > 0 {
+				logg.Info("%d credentials have moved to different memcached servers", len(movedCreds))
+				select {
+				case moved <- movedCreds:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
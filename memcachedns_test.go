@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver is a dnsResolver with fixed records.
+type fakeResolver struct {
+	mutex      sync.Mutex
+	SRVRecords map[string][]*net.SRV // key = name
+	Hosts      map[string][]string   // key = hostname, value = IPs
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	records, exists := r.SRVRecords[name]
+	if !exists {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ips, exists := r.Hosts[host]
+	if !exists {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func TestResolveMemcacheServers(t *testing.T) {
+	resolver := &fakeResolver{
+		SRVRecords: map[string][]*net.SRV{
+			"_memcache._tcp.memcached.example.com": {
+				{Target: "memcached-0.memcached.example.com.", Port: 11211},
+				{Target: "memcached-1.memcached.example.com.", Port: 11212},
+			},
+		},
+		Hosts: map[string][]string{
+			"memcached.example.com": {"10.0.0.1", "fd00::1"},
+		},
+	}
+
+	servers, err := resolveMemcacheServers(t.Context(), resolver, []string{
+		"dns+srv://_memcache._tcp.memcached.example.com",
+		"dns://memcached.example.com",
+		"dns://memcached.example.com:11213",
+		"static.example.com:11211",
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := []string{
+		"memcached-0.memcached.example.com:11211",
+		"memcached-1.memcached.example.com:11212",
+		"10.0.0.1:11211",
+		"[fd00::1]:11211",
+		"10.0.0.1:11213",
+		"[fd00::1]:11213",
+		"static.example.com:11211",
+	}
+	if !slices.Equal(servers, expected) {
+		t.Errorf("expected %v, but got %v", expected, servers)
+	}
+
+	_, err = resolveMemcacheServers(t.Context(), resolver, []string{"dns://unknown.example.com"})
+	if err == nil {
+		t.Error("expected error for unknown host, but got none")
+	}
+}
+
+func TestMemcacheServersChange(t *testing.T) {
+	var creds []CredentialID
+	for idx := range 20 {
+		creds = append(creds, CredentialID{UserID: fmt.Sprintf("uid%d", idx), AccessKey: fmt.Sprintf("key%d", idx)})
+	}
+	p, _, fm1 := newTestPrewarmer(t, creds...)
+	fm2 := newFakeMemcached(t)
+
+	// with only one try, each credential is only placed on one server
+	resolver := &fakeResolver{SRVRecords: make(map[string][]*net.SRV)}
+	setServers := func(fms ...*fakeMemcached) {
+		resolver.mutex.Lock()
+		defer resolver.mutex.Unlock()
+		var records []*net.SRV
+		for _, fm := range fms {
+			host, port, _ := net.SplitHostPort(fm.Address())
+			portNum, _ := strconv.Atoi(port)
+			records = append(records, &net.SRV{Target: host + ".", Port: uint16(portNum)})
+		}
+		resolver.SRVRecords["memcached.example.com"] = records
+	}
+	setServers(fm1)
+	specs := []string{"dns+srv://memcached.example.com"}
+	servers, err := resolveMemcacheServers(t.Context(), resolver, specs)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	p.Memcache, err = NewMemcacheClient(servers, MemcacheOptions{Tries: 1})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	p.doPrewarmCycle(t.Context(), 0)
+
+	// when a server is added, the credentials that now belong onto it are reported as moved
+	setServers(fm1, fm2)
+	moved := make(chan []CredentialID)
+	go runMemcacheDNSLoop(t.Context(), p, resolver, specs, 10*time.Millisecond, moved)
+	var movedCreds []CredentialID
+	select {
+	case movedCreds = <-moved:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout while waiting for server change")
+	}
+	if len(movedCreds) == 0 || len(movedCreds) == len(creds) {
+		t.Errorf("expected some, but not all credentials to move, but %d of %d moved", len(movedCreds), len(creds))
+	}
+	for _, cred := range creds {
+		_, isOnNewServer := fm2.Get(cred.CacheKey())
+		if isOnNewServer {
+			t.Errorf("expected credential %q to not be on the new server before prewarming", cred.String())
+		}
+		placement := p.Memcache.Ring.Placement(cred.CacheKey())
+		if slices.Contains(movedCreds, cred) != (placement[0] == fm2.Address()) {
+			t.Errorf("expected credential %q to be reported as moved if and only if it is placed on the new server", cred.String())
+		}
+	}
+
+	// after prewarming the moved credentials, all credentials are cached on their respective servers
+	p.prewarmCredentials(t.Context(), scheduleRefreshes(movedCreds, time.Now(), 0), 0)
+	for _, cred := range creds {
+		fm := fm1
+		if slices.Contains(movedCreds, cred) {
+			fm = fm2
+		}
+		if _, exists := fm.Get(cred.CacheKey()); !exists {
+			t.Errorf("expected credential %q to be cached on %s", cred.String(), fm.Address())
+		}
+	}
+
+	// resolving the same servers again does not report any moves
+	movedCreds, err = updateMemcacheServers(p, []string{fm2.Address(), fm1.Address()})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(movedCreds) > 0 {
+		t.Errorf("expected no credentials to move, but got %v", movedCreds)
+	}
+}
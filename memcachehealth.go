@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+)
+
+var (
+	memcacheUpGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_memcache_up",
+			Help: "Whether the last health probe of this memcached server succeeded (1) or failed (0).",
+		},
+		[]string{"server"},
+	)
+	memcacheLatencySecsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_memcache_latency_secs",
+			Help: `Duration in seconds of the "version" command in the last successful health probe of this memcached server.`,
+		},
+		[]string{"server"},
+	)
+	memcacheEvictionsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_memcache_evictions",
+			Help: `Number of items that this memcached server evicted to free memory since it was started (the "evictions" statistic).`,
+		},
+		[]string{"server"},
+	)
+	memcacheCurrItemsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_memcache_curr_items",
+			Help: `Number of items currently stored on this memcached server (the "curr_items" statistic).`,
+		},
+		[]string{"server"},
+	)
+)
+
+// memcacheProbeResult is the result of probeMemcacheServer().
+type memcacheProbeResult struct {
+	Latency time.Duration
+	// from the output of the "stats" command
+	Stats map[string]string
+}
+
+// probeMemcacheServer checks the health of a single memcached server, using
+// the same connection settings as the given client.
+func probeMemcacheServer(ctx context.Context, mc MemcacheClient, addr net.Addr) (result memcacheProbeResult, err error) {
+	timeout := mc.Timeout
+	if timeout == 0 {
+		timeout = memcache.DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := mc.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, addr.Network(), addr.String())
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return result, err
+		}
+	}
+	rw := bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	// the "version" command is the cheapest command that needs a roundtrip to memcached
+	start := time.Now()
+	line, err := memcacheRoundtrip(rw, "version")
+	if err != nil {
+		return result, err
+	}
+	if !strings.HasPrefix(line, "VERSION ") {
+		return result, fmt.Errorf("unexpected response to version command: %q", line)
+	}
+	result.Latency = time.Since(start)
+
+	// the "stats" command returns lines of the form "STAT <name> <value>", terminated by "END"
+	result.Stats = make(map[string]string)
+	line, err = memcacheRoundtrip(rw, "stats")
+	for ; err == nil && line != "END"; line, err = readMemcacheLine(rw) {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "STAT" {
+			return result, fmt.Errorf("unexpected response to stats command: %q", line)
+		}
+		result.Stats[fields[1]] = fields[2]
+	}
+	return result, err
+}
+
+// memcacheRoundtrip sends a command and reads the first line of the response.
+func memcacheRoundtrip(rw *bufio.ReadWriter, command string) (string, error) {
+	_, err := rw.WriteString(command + "\r\n")
+	if err == nil {
+		err = rw.Flush()
+	}
+	if err != nil {
+		return "", err
+	}
+	return readMemcacheLine(rw)
+}
+
+func readMemcacheLine(rw *bufio.ReadWriter) (string, error) {
+	line, err := rw.ReadString('\n')
+	return strings.TrimSuffix(line, "\r\n"), err
+}
+
+// memcacheHealthChecker probes all memcached servers in the ring of a
+// MemcacheClient, and reports the results as metrics.
+type memcacheHealthChecker struct {
+	Memcache MemcacheClient
+
+	mutex sync.Mutex
+	// key = server address, value = whether the last probe succeeded
+	isUp map[string]bool
+}
+
+// UpServers returns how many servers passed their last health probe, out of
+// how many servers were probed.
+func (h *memcacheHealthChecker) UpServers() (up, total int) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for _, isUp := range h.isUp {
+		if isUp {
+			up++
+		}
+	}
+	return up, len(h.isUp)
+}
+
+// CheckAll probes all servers concurrently and updates the metrics.
+func (h *memcacheHealthChecker) CheckAll(ctx context.Context) {
+	var addrs []net.Addr
+	for _, server := range h.Memcache.Ring.Servers() {
+		addr, err := parseMemcacheServer(server)
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		results = make([]memcacheProbeResult, len(addrs))
+		errs    = make([]error, len(addrs))
+	)
+	for idx, addr := range addrs {
+		wg.Go(func() { results[idx], errs[idx] = probeMemcacheServer(ctx, h.Memcache, addr) })
+	}
+	wg.Wait()
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.isUp == nil {
+		h.isUp = make(map[string]bool)
+	}
+	seen := make(map[string]bool, len(addrs))
+	for idx, addr := range addrs {
+		server := addr.String()
+		seen[server] = true
+		wasUp, known := h.isUp[server]
+		labels := prometheus.Labels{"server": server}
+		if errs[idx] != nil {
+			if wasUp || !known {
+				logg.Error("memcached server %s is unavailable: %s", server, errs[idx].Error())
+			}
+			h.isUp[server] = false
+			memcacheUpGauge.With(labels).Set(0)
+			memcacheLatencySecsGauge.Delete(labels)
+			memcacheEvictionsGauge.Delete(labels)
+			memcacheCurrItemsGauge.Delete(labels)
+			continue
+		}
+
+		if known && !wasUp {
+			logg.Info("memcached server %s is available again", server)
+		}
+		h.isUp[server] = true
+		memcacheUpGauge.With(labels).Set(1)
+		memcacheLatencySecsGauge.With(labels).Set(results[idx].Latency.Seconds())
+		setGaugeFromMemcacheStat(memcacheEvictionsGauge.With(labels), results[idx].Stats["evictions"])
+		setGaugeFromMemcacheStat(memcacheCurrItemsGauge.With(labels), results[idx].Stats["curr_items"])
+	}
+
+	// forget about servers that were removed from the ring
+	for server := range h.isUp {
+		if !seen[server] {
+			delete(h.isUp, server)
+			labels := prometheus.Labels{"server": server}
+			memcacheUpGauge.Delete(labels)
+			memcacheLatencySecsGauge.Delete(labels)
+			memcacheEvictionsGauge.Delete(labels)
+			memcacheCurrItemsGauge.Delete(labels)
+		}
+	}
+}
+
+func setGaugeFromMemcacheStat(gauge prometheus.Gauge, value string) {
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err == nil {
+		gauge.Set(parsed)
+	}
+}
+
+// runMemcacheHealthLoop probes all memcached servers in regular intervals.
+func runMemcacheHealthLoop(ctx context.Context, h *memcacheHealthChecker, interval time.Duration) {
+	h.CheckAll(ctx)
+	tick := time.Tick(interval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			h.CheckAll(ctx)
+		}
+	}
+}
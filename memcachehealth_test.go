@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMemcacheHealthChecker(t *testing.T) {
+	fm1 := newFakeMemcached(t)
+	fm2 := newFakeMemcached(t)
+	fm1.Set("foo", []byte("bar"))
+	fm1.Set("qux", []byte("baz"))
+	mc := MustConnectToMemcache([]string{fm1.Address(), fm2.Address()}, MemcacheOptions{})
+	h := &memcacheHealthChecker{Memcache: mc}
+
+	expectMetrics := func(server string, up, currItems float64) {
+		t.Helper()
+		actualUp := getMetricValue(t, memcacheUpGauge.WithLabelValues(server))
+		if actualUp != up {
+			t.Errorf("expected up = %g for %s, but got %g", up, server, actualUp)
+		}
+		if up == 0 {
+			return
+		}
+		actualCurrItems := getMetricValue(t, memcacheCurrItemsGauge.WithLabelValues(server))
+		if actualCurrItems != currItems {
+			t.Errorf("expected curr_items = %g for %s, but got %g", currItems, server, actualCurrItems)
+		}
+		if getMetricValue(t, memcacheLatencySecsGauge.WithLabelValues(server)) <= 0 {
+			t.Errorf("expected positive latency for %s", server)
+		}
+	}
+
+	h.CheckAll(t.Context())
+	expectMetrics(fm1.Address(), 1, 2)
+	expectMetrics(fm2.Address(), 1, 0)
+
+	// when a server stops accepting connections, it is reported as down...
+	fm2.Listener.Close()
+	h.CheckAll(t.Context())
+	expectMetrics(fm1.Address(), 1, 2)
+	expectMetrics(fm2.Address(), 0, 0)
+
+	// ...until it comes back
+	listener, err := net.Listen("tcp", fm2.Address())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	fm2 = startFakeMemcached(t, listener)
+	fm2.Set("foo", []byte("bar"))
+	h.CheckAll(t.Context())
+	expectMetrics(fm2.Address(), 1, 1)
+
+	// the metrics of servers that are removed from the ring are deleted
+	err = mc.Ring.SetServers(fm1.Address())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	h.CheckAll(t.Context())
+	expectMetrics(fm1.Address(), 1, 2)
+	if memcacheUpGauge.DeleteLabelValues(fm2.Address()) {
+		t.Errorf("expected metrics for %s to be deleted", fm2.Address())
+	}
+}
@@ -0,0 +1,978 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+)
+
+var (
+	prewarmTimestampSecsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_last_run_secs",
+			Help: "UNIX timestamp in seconds of last successful cache prewarm for a particular S3 credential.",
+		},
+		[]string{"userid", "accesskey"},
+	)
+	prewarmDurationSecsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_duration_secs",
+			Help: "Duration in seconds of last successful cache prewarm for a particular S3 credential.",
+		},
+		[]string{"userid", "accesskey"},
+	)
+	casConflictsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_cas_conflicts_total",
+			Help: "Counts how often a cache entry for a particular S3 credential was changed (or deleted) by someone else while being updated in conservative mode.",
+		},
+		[]string{"userid", "accesskey"},
+	)
+	refreshesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_refreshes_total",
+			Help: `Counts successful cache prewarms for a particular S3 credential. The label "action" is "touch" if only the expiration time was updated because the cache entry was unchanged, "rewrite" if a changed cache entry was overwritten (or a lost or outdated replica was rewritten), or "create" if the cache entry did not exist.`,
+		},
+		[]string{"userid", "accesskey", "action"},
+	)
+	nextRefreshSecsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_next_refresh_secs",
+			Help: "UNIX timestamp in seconds of the next scheduled cache prewarm for a particular S3 credential. For credentials that were added since the current prewarm cycle started, this is an estimate (one cycle length after they were first prewarmed).",
+		},
+		[]string{"userid", "accesskey"},
+	)
+	staleCredentialsGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_stale_credentials",
+			Help: "Number of S3 credentials whose cache entry is kept alive without being verified in Keystone because Keystone is unavailable.",
+		},
+	)
+	staleSecsGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_stale_secs",
+			Help: "For S3 credentials whose cache entry is kept alive because Keystone is unavailable, the time in seconds since the cache entry was last verified in Keystone.",
+		},
+		[]string{"userid", "accesskey"},
+	)
+	overrunCyclesCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_overrun_cycles_total",
+			Help: "Counts prewarm cycles that did not finish before the next cycle was due (and therefore skipped some credentials).",
+		},
+	)
+	evictionsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_evictions_total",
+			Help: "Counts how often a particular S3 credential was deleted from Memcache because it was revoked in Keystone.",
+		},
+		[]string{"userid", "accesskey", "reason"},
+	)
+	attemptsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_attempts_total",
+			Help: "Counts attempts to prewarm a particular S3 credential (successful or not).",
+		},
+		[]string{"userid", "accesskey"},
+	)
+	failuresCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_failures_total",
+			Help: `Counts failed attempts to prewarm a particular S3 credential. The label "reason" is "keystone_not_found", "keystone_unauthorized", "keystone_error", "memcache_error" or "conservative_conflict".`,
+		},
+		[]string{"userid", "accesskey", "reason"},
+	)
+	cycleDurationSecsHistogram = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "swift_s3_cache_prewarm_cycle_duration_secs",
+			Help:    "Duration in seconds of complete prewarm cycles (including the time between refreshes that are spread out over the cycle).",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		},
+	)
+	credentialLabelGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_credential_label",
+			Help: "Has value 1 for each label that is configured for a particular S3 credential in the config file.",
+		},
+		[]string{"userid", "accesskey", "label", "value"},
+	)
+)
+
+// Prewarmer contains the state of the prewarm command.
+type Prewarmer struct {
+	Keystone *KeystoneClient
+	Memcache MemcacheClient
+	// These are the defaults for credentials that do not have their own
+	// settings in the config file.
+	Conservative bool
+	Expiry       time.Duration
+	// Concurrency is the maximum number of credentials that are prewarmed at
+	// the same time (values below 1 are treated as 1).
+	Concurrency int
+	// RequestTimeout limits how long the prewarm of a single credential may
+	// take (0 means no limit).
+	RequestTimeout time.Duration
+	// If MaxStaleness is not zero, the lifetime of cache entries is extended
+	// when Keystone is unavailable, until the cache entry was last verified in
+	// Keystone MaxStaleness ago.
+	MaxStaleness time.Duration
+	// If Evict is true, cache entries of credentials that were deleted in
+	// Keystone (or cannot be used to login anymore) are deleted from Memcache.
+	Evict bool
+
+	mutex sync.Mutex
+	// credentials from the config file or the command line
+	configuredCredentials []CredentialConfig
+	// credentials found by Keystone discovery
+	discoveredCredentials []CredentialID
+	// credentials added through the admin API
+	runtimeCredentials []CredentialID
+	// the union of all these sets
+	credentials []CredentialID
+	settings    map[CredentialID]CredentialConfig
+	statuses    map[CredentialID]*CredentialStatus
+	// progress of prewarm cycles (see CycleStatus())
+	cycleStartedAt  time.Time
+	cycleLength     time.Duration
+	completedCycles int
+}
+
+// CycleStatus reports when the current (or most recent) prewarm cycle was
+// started, its cycle length, and how many cycles were completed so far.
+func (p *Prewarmer) CycleStatus() (startedAt time.Time, cycleLength time.Duration, completedCycles int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.cycleStartedAt, p.cycleLength, p.completedCycles
+}
+
+// CountFreshCredentials returns how many credentials were successfully
+// prewarmed within their expiry (i.e. their cache entry is known to be
+// valid), out of how many credentials are being prewarmed.
+func (p *Prewarmer) CountFreshCredentials(now time.Time) (fresh, total int) {
+	for _, cred := range p.Credentials() {
+		expiry, _ := p.settingsFor(cred)
+		status, exists := p.Status(cred)
+		if !exists {
+			// credential was removed in the meantime
+			continue
+		}
+		total++
+		if !status.LastSuccessAt.IsZero() && now.Sub(status.LastSuccessAt) < expiry {
+			fresh++
+		}
+	}
+	return fresh, total
+}
+
+// CredentialStatus describes the outcome of the most recent prewarm attempts
+// for a single credential.
+type CredentialStatus struct {
+	LastSuccessAt time.Time
+	LastFailureAt time.Time
+	// LastError is the error from the last failed attempt (or nil if the last
+	// attempt was successful), and LastFailureReason is its label on
+	// failuresCounter.
+	LastError         error
+	LastFailureReason string
+	// Stale is true while the cache entry is kept alive without being verified
+	// in Keystone (see Prewarmer.MaxStaleness).
+	Stale bool
+	// NextRefreshAt is when the next prewarm of this credential is scheduled
+	// (or an estimate thereof, see recordImmediateRefresh()).
+	NextRefreshAt time.Time
+
+	// the payload that we last wrote into Memcache (used by eviction in
+	// conservative mode)
+	lastWrittenPayload *CredentialPayload
+}
+
+// Credentials returns the list of credentials that are being prewarmed.
+func (p *Prewarmer) Credentials() []CredentialID {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return slices.Clone(p.credentials)
+}
+
+// SetConfiguredCredentials replaces the set of credentials that are
+// prewarmed because they were given in the config file or on the command line.
+func (p *Prewarmer) SetConfiguredCredentials(creds []CredentialConfig) (added, removed []CredentialID) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.configuredCredentials = slices.Clone(creds)
+	return p.updateCredentialsLocked()
+}
+
+// SetDiscoveredCredentials replaces the set of credentials that are
+// prewarmed because they were found by Keystone discovery.
+func (p *Prewarmer) SetDiscoveredCredentials(creds []CredentialID) (added, removed []CredentialID) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.discoveredCredentials = slices.Clone(creds)
+	return p.updateCredentialsLocked()
+}
+
+// AddRuntimeCredential adds a credential to the set of credentials that are
+// prewarmed because they were added through the admin API. Returns false if
+// it had already been added in this way.
+func (p *Prewarmer) AddRuntimeCredential(cred CredentialID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if slices.Contains(p.runtimeCredentials, cred) {
+		return false
+	}
+	p.runtimeCredentials = append(p.runtimeCredentials, cred)
+	p.updateCredentialsLocked()
+	return true
+}
+
+// RemoveRuntimeCredential reverts AddRuntimeCredential. Returns false if the
+// credential had not been added in this way. The credential is still
+// prewarmed if it is also configured or discovered.
+func (p *Prewarmer) RemoveRuntimeCredential(cred CredentialID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	idx := slices.Index(p.runtimeCredentials, cred)
+	if idx < 0 {
+		return false
+	}
+	p.runtimeCredentials = slices.Delete(p.runtimeCredentials, idx, idx+1)
+	p.updateCredentialsLocked()
+	return true
+}
+
+// CredentialSources returns where the given credential comes from: "config"
+// (config file, credentials file or command line), "discovery" and/or
+// "admin" (admin API). Returns nil if the credential is not prewarmed.
+func (p *Prewarmer) CredentialSources(cred CredentialID) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var result []string
+	if slices.ContainsFunc(p.configuredCredentials, func(cfg CredentialConfig) bool { return cfg.ID == cred }) {
+		result = append(result, "config")
+	}
+	if slices.Contains(p.discoveredCredentials, cred) {
+		result = append(result, "discovery")
+	}
+	if slices.Contains(p.runtimeCredentials, cred) {
+		result = append(result, "admin")
+	}
+	return result
+}
+
+// Recomputes p.credentials after the configured, discovered or runtime
+// credentials have changed. Status and Prometheus metrics of removed credentials are deleted.
+func (p *Prewarmer) updateCredentialsLocked() (added, removed []CredentialID) {
+	if p.statuses == nil {
+		p.statuses = make(map[CredentialID]*CredentialStatus)
+	}
+
+	var creds []CredentialID
+	settings := make(map[CredentialID]CredentialConfig, len(p.configuredCredentials))
+	for _, cfg := range p.configuredCredentials {
+		creds = append(creds, cfg.ID)
+		settings[cfg.ID] = cfg
+	}
+	creds = mergeCredentials(creds, p.discoveredCredentials)
+	creds = mergeCredentials(creds, p.runtimeCredentials)
+
+	isNew := make(map[CredentialID]bool, len(creds))
+	for _, cred := range creds {
+		isNew[cred] = true
+	}
+	for _, cred := range p.credentials {
+		if isNew[cred] {
+			delete(isNew, cred)
+			continue
+		}
+		removed = append(removed, cred)
+		delete(p.statuses, cred)
+		labels := cred.AsLabels()
+		prewarmTimestampSecsGauge.Delete(labels)
+		prewarmDurationSecsGauge.Delete(labels)
+		nextRefreshSecsGauge.Delete(labels)
+		staleSecsGauge.Delete(labels)
+		evictionsCounter.DeletePartialMatch(labels)
+		refreshesCounter.DeletePartialMatch(labels)
+		casConflictsCounter.Delete(labels)
+		attemptsCounter.Delete(labels)
+		failuresCounter.DeletePartialMatch(labels)
+		credentialLabelGauge.DeletePartialMatch(labels)
+		if p.Keystone != nil && p.Keystone.SecretCache != nil {
+			p.Keystone.SecretCache.Forget(cred)
+		}
+		logg.Info("credential %q will not be prewarmed anymore", cred.String())
+	}
+	for _, cred := range creds {
+		// labels may change even if the credential stays the same
+		credentialLabelGauge.DeletePartialMatch(cred.AsLabels())
+		for key, value := range settings[cred].Labels {
+			credentialLabelGauge.With(withLabels(cred.AsLabels(), "label", key, "value", value)).Set(1)
+		}
+
+		if !isNew[cred] {
+			continue
+		}
+		added = append(added, cred)
+		p.statuses[cred] = &CredentialStatus{}
+		// make sure that all swift_s3_cache_prewarm_last_run_secs timeseries exist,
+		// even if prewarm never succeeds
+		prewarmTimestampSecsGauge.With(cred.AsLabels()).Set(0)
+		logg.Info("credential %q will be prewarmed", cred.String())
+	}
+
+	p.credentials = creds
+	p.settings = settings
+	p.countStaleCredentialsLocked()
+	return added, removed
+}
+
+// settingsFor returns the effective settings for the given credential.
+func (p *Prewarmer) settingsFor(cred CredentialID) (expiry time.Duration, conservative bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	cfg := p.settings[cred]
+
+	expiry = p.Expiry
+	if cfg.Expiry != 0 {
+		expiry = cfg.Expiry
+	}
+	conservative = p.Conservative
+	if cfg.Conservative != nil {
+		conservative = *cfg.Conservative
+	}
+	return expiry, conservative
+}
+
+// Status returns the status of the given credential.
+func (p *Prewarmer) Status(cred CredentialID) (CredentialStatus, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	status, exists := p.statuses[cred]
+	if !exists {
+		return CredentialStatus{}, false
+	}
+	return *status, true
+}
+
+func (p *Prewarmer) recordResult(cred CredentialID, prewarmStart, prewarmEnd time.Time, failureReason string, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	status, exists := p.statuses[cred]
+	if !exists {
+		// credential was removed while being prewarmed
+		return
+	}
+	status.LastError = err
+	status.LastFailureReason = failureReason
+	if err != nil {
+		status.LastFailureAt = prewarmEnd
+		return
+	}
+	status.LastSuccessAt = prewarmEnd
+	if status.Stale {
+		p.setStaleLocked(cred, status, false, prewarmEnd)
+	}
+
+	// report Prometheus metrics for this prewarm run
+	labels := cred.AsLabels()
+	prewarmTimestampSecsGauge.With(labels).Set(float64(prewarmEnd.Unix()))
+	prewarmDurationSecsGauge.With(labels).Set(float64(prewarmEnd.Sub(prewarmStart)) / float64(time.Second))
+}
+
+// CredentialResult is the outcome of the most recent prewarm attempt for a
+// single credential, as reported by `prewarm --once`.
+type CredentialResult struct {
+	UserID    string `json:"userid"`
+	AccessKey string `json:"accesskey"`
+	Success   bool   `json:"success"`
+	// only set if Success is false
+	Error         string `json:"error,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+}
+
+// Results reports the outcome of the most recent prewarm attempt for each
+// credential. Credentials that were never attempted count as failed.
+func (p *Prewarmer) Results() []CredentialResult {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	result := make([]CredentialResult, len(p.credentials))
+	for idx, cred := range p.credentials {
+		status := p.statuses[cred]
+		result[idx] = CredentialResult{UserID: cred.UserID, AccessKey: cred.AccessKey}
+		switch {
+		case status.LastError != nil:
+			result[idx].Error = status.LastError.Error()
+			result[idx].FailureReason = status.LastFailureReason
+		case status.LastSuccessAt.IsZero():
+			result[idx].Error = "credential was not prewarmed"
+		default:
+			result[idx].Success = true
+		}
+	}
+	return result
+}
+
+func withLabels(labels prometheus.Labels, keysAndValues ...string) prometheus.Labels {
+	for idx := 0; idx+1 < len(keysAndValues); idx += 2 {
+		labels[keysAndValues[idx]] = keysAndValues[idx+1]
+	}
+	return labels
+}
+
+// CycleLength returns the interval in which all credentials need to be
+// prewarmed, such that the credential with the shortest expiry is refreshed
+// well before it expires.
+func (p *Prewarmer) CycleLength() time.Duration {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	minExpiry := p.Expiry
+	for _, cfg := range p.settings {
+		if cfg.Expiry != 0 && cfg.Expiry < minExpiry {
+			minExpiry = cfg.Expiry
+		}
+	}
+	return minExpiry / 5
+}
+
+func (p *Prewarmer) isPrewarmed(cred CredentialID) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, exists := p.statuses[cred]
+	return exists
+}
+
+// doPrewarmCycle prewarms all credentials once, with the refreshes being
+// spread out over the given cycle length (see scheduleRefreshes()).
+//
+// If ctx has a deadline, credentials that could not be started before the
+// deadline are skipped. They will be prewarmed first in the next cycle,
+// because credentials are processed in order of their last prewarm attempt.
+func (p *Prewarmer) doPrewarmCycle(ctx context.Context, cycleLength time.Duration) {
+	start := time.Now()
+	p.mutex.Lock()
+	p.cycleStartedAt = start
+	p.cycleLength = cycleLength
+	p.mutex.Unlock()
+
+	creds := p.credentialsByLastAttempt()
+	schedule := scheduleRefreshes(creds, start, cycleLength)
+	skipped := p.prewarmCredentials(ctx, schedule, cycleLength)
+	cycleDurationSecsHistogram.Observe(time.Since(start).Seconds())
+
+	p.mutex.Lock()
+	p.completedCycles++
+	p.mutex.Unlock()
+	if skipped > 0 {
+		overrunCyclesCounter.Inc()
+		logg.Error("prewarm cycle did not finish in time: skipped %d of %d credentials", skipped, len(creds))
+	}
+}
+
+// runPrewarmLoop runs prewarm cycles until ctx expires. Since regular cycles
+// run in the background, the credentials received from the channels are
+// prewarmed immediately, even while a cycle is in progress:
+//
+//   - `reloaded` receives the credentials added by each reload (see runReloadLoop()),
+//   - `moved` receives credentials that moved to different memcached servers (see runMemcacheDNSLoop()),
+//   - `triggered` receives credentials that were selected through the admin API (see AdminAPI).
+func runPrewarmLoop(ctx context.Context, p *Prewarmer, reloaded, moved, triggered <-chan []CredentialID) {
+	cycleLength := p.CycleLength()
+	cycleLengths := make(chan time.Duration, 1)
+	var wg sync.WaitGroup
+	wg.Go(func() { runPrewarmCycles(ctx, p, cycleLength, cycleLengths) })
+	defer wg.Wait()
+
+	prewarmImmediately := func(creds []CredentialID) {
+		// like a regular cycle, this must be finished when the next cycle is due
+		immediateCtx, cancel := context.WithTimeout(ctx, cycleLength)
+		defer cancel()
+		p.prewarmCredentials(immediateCtx, scheduleRefreshes(creds, time.Now(), 0), 0)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case creds := <-reloaded:
+			// newly added credentials are prewarmed immediately, without waiting for their slot in the next cycle
+			if len(creds) > 0 {
+				prewarmImmediately(creds)
+			}
+			// the reload may have added, removed or changed the credential with the shortest expiry
+			if newCycleLength := p.CycleLength(); newCycleLength != cycleLength {
+				cycleLength = newCycleLength
+				select {
+				case <-cycleLengths:
+					// replace a previous cycle length that was not picked up yet
+				default:
+				}
+				cycleLengths <- cycleLength
+			}
+		case creds := <-moved:
+			// credentials that moved to different memcached servers are not cached there yet
+			prewarmImmediately(creds)
+		case creds := <-triggered:
+			prewarmImmediately(creds)
+		}
+	}
+}
+
+// runPrewarmCycles runs the regular prewarm cycles for runPrewarmLoop(),
+// starting with one immediately. Changes to the cycle length are received
+// through the given channel.
+func runPrewarmCycles(ctx context.Context, p *Prewarmer, cycleLength time.Duration, cycleLengths <-chan time.Duration) {
+	ticker := time.NewTicker(cycleLength)
+	defer ticker.Stop()
+	for {
+		// a cycle must be finished when the next one is due
+		cycleCtx, cancel := context.WithTimeout(ctx, cycleLength)
+		p.doPrewarmCycle(cycleCtx, cycleLength)
+		cancel()
+
+	waitForNextCycle:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break waitForNextCycle
+			case cycleLength = <-cycleLengths:
+				ticker.Reset(cycleLength)
+			}
+		}
+	}
+}
+
+func (p *Prewarmer) credentialsByLastAttempt() []CredentialID {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	lastAttemptAt := func(cred CredentialID) time.Time {
+		status := p.statuses[cred]
+		if status.LastFailureAt.After(status.LastSuccessAt) {
+			return status.LastFailureAt
+		}
+		return status.LastSuccessAt
+	}
+
+	result := slices.Clone(p.credentials)
+	slices.SortStableFunc(result, func(lhs, rhs CredentialID) int {
+		return lastAttemptAt(lhs).Compare(lastAttemptAt(rhs))
+	})
+	return result
+}
+
+// prewarmCredentials prewarms credentials according to the given schedule,
+// with up to p.Concurrency credentials being worked on at the same time.
+// Returns how many credentials were skipped because ctx expired.
+//
+// If cycleLength is not zero, each credential is expected to be refreshed
+// again one cycle length after its scheduled time. Otherwise, this is an
+// immediate prewarm outside of the regular prewarm cycle (e.g. for newly
+// added credentials), which does not change when the credentials are
+// refreshed next by the regular prewarm cycle.
+func (p *Prewarmer) prewarmCredentials(ctx context.Context, schedule []scheduledRefresh, cycleLength time.Duration) (skipped int) {
+	if cycleLength > 0 {
+		p.recordSchedule(schedule)
+	}
+
+	queue := make(chan scheduledRefresh)
+	var wg sync.WaitGroup
+	for range max(p.Concurrency, 1) {
+		wg.Go(func() {
+			for refresh := range queue {
+				p.prewarmCredentialAndRecord(ctx, refresh.Credential)
+				if cycleLength > 0 {
+					p.recordSchedule([]scheduledRefresh{{refresh.Credential, refresh.At.Add(cycleLength)}})
+				} else {
+					p.recordImmediateRefresh(refresh.Credential, time.Now())
+				}
+			}
+		})
+	}
+
+	for idx, refresh := range schedule {
+		timer := time.NewTimer(time.Until(refresh.At))
+		select {
+		case <-timer.C:
+			select {
+			case queue <- refresh:
+				continue
+			case <-ctx.Done():
+			}
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		skipped = len(schedule) - idx
+		break
+	}
+	close(queue)
+	wg.Wait()
+	return skipped
+}
+
+// recordSchedule updates the next refresh time of the given credentials.
+func (p *Prewarmer) recordSchedule(schedule []scheduledRefresh) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, refresh := range schedule {
+		status, exists := p.statuses[refresh.Credential]
+		if !exists {
+			// credential was removed in the meantime
+			continue
+		}
+		status.NextRefreshAt = refresh.At
+		nextRefreshSecsGauge.With(refresh.Credential.AsLabels()).Set(float64(refresh.At.Unix()))
+	}
+}
+
+// recordImmediateRefresh updates the next refresh time of a credential that
+// was prewarmed outside of the regular prewarm cycle. If the credential does
+// not have an upcoming slot in the current cycle (e.g. because it was added
+// after the cycle started), it will be refreshed within the next cycle, so
+// the end of that cycle is recorded as an estimate.
+func (p *Prewarmer) recordImmediateRefresh(cred CredentialID, now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	status, exists := p.statuses[cred]
+	if !exists || status.NextRefreshAt.After(now) || p.cycleLength == 0 {
+		return
+	}
+	status.NextRefreshAt = now.Add(p.cycleLength)
+	nextRefreshSecsGauge.With(cred.AsLabels()).Set(float64(status.NextRefreshAt.Unix()))
+}
+
+func (p *Prewarmer) prewarmCredentialAndRecord(ctx context.Context, cred CredentialID) {
+	if !p.isPrewarmed(cred) {
+		// credential was removed since the list was obtained
+		return
+	}
+
+	// each credential gets its own timeout, so that a single hanging request
+	// does not hold up a worker for the entire cycle
+	if p.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.RequestTimeout)
+		defer cancel()
+	}
+
+	prewarmStart := time.Now()
+	attemptsCounter.With(cred.AsLabels()).Inc()
+	failureReason, err := p.prewarmCredential(ctx, cred)
+	if err != nil {
+		// a single failure shall not stop us from prewarming all the other credentials
+		logg.Error("skipping credential %q: %s", cred.String(), err.Error())
+		failuresCounter.With(withLabels(cred.AsLabels(), "reason", failureReason)).Inc()
+	}
+	p.recordResult(cred, prewarmStart, time.Now(), failureReason, err)
+}
+
+// prewarmCredential prewarms a single credential. If it fails, the returned
+// failure reason is used as a label on failuresCounter.
+func (p *Prewarmer) prewarmCredential(ctx context.Context, cred CredentialID) (failureReason string, err error) {
+	expiry, conservative := p.settingsFor(cred)
+
+	// get new payload from Keystone
+	payload, err := GetCredentialFromKeystone(ctx, p.Keystone, cred)
+	if err != nil {
+		failureReason = "keystone_error"
+		switch {
+		case errors.Is(err, ErrNotFound):
+			failureReason = "keystone_not_found"
+		case errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrForbidden):
+			failureReason = "keystone_unauthorized"
+		}
+		if p.Evict && (errors.Is(err, ErrNotFound) || errors.Is(err, ErrUnauthorized)) {
+			evictErr := p.evictCredential(cred, conservative, err)
+			if evictErr != nil {
+				return failureReason, errors.Join(err, evictErr)
+			}
+		}
+		if p.MaxStaleness > 0 && errors.Is(err, ErrTransient) {
+			extendErr := p.extendStaleCredential(cred, expiry, conservative)
+			if extendErr != nil {
+				return failureReason, errors.Join(err, extendErr)
+			}
+		}
+		return failureReason, err
+	}
+
+	var action string
+	if conservative {
+		action, err = p.refreshConservatively(ctx, cred, *payload, expiry)
+	} else {
+		action, err = p.refresh(cred, *payload, expiry)
+	}
+	if err != nil {
+		if errors.Is(err, errConservativeConflict) || isCASConflict(err) {
+			return "conservative_conflict", err
+		}
+		return "memcache_error", err
+	}
+	refreshesCounter.With(withLabels(cred.AsLabels(), "action", action)).Inc()
+	p.rememberWrittenPayload(cred, payload)
+	logg.Info("credential %q was prewarmed (%s)", cred.String(), action)
+	return "", nil
+}
+
+// extendStaleCredential is called when Keystone is unavailable. If the cache
+// entry was previously verified in Keystone and written by us, its lifetime is
+// extended (but not beyond the point where it was last verified
+// p.MaxStaleness ago), so that a Keystone outage does not turn into an S3
+// outage once all cache entries expire.
+func (p *Prewarmer) extendStaleCredential(cred CredentialID, expiry time.Duration, conservative bool) error {
+	now := time.Now()
+	p.mutex.Lock()
+	var (
+		lastVerifiedAt     time.Time
+		lastWrittenPayload *CredentialPayload
+	)
+	if status, exists := p.statuses[cred]; exists {
+		lastVerifiedAt = status.LastSuccessAt
+		lastWrittenPayload = status.lastWrittenPayload
+	}
+	p.mutex.Unlock()
+	if lastWrittenPayload == nil {
+		// we cannot vouch for a cache entry that we did not write ourselves
+		return nil
+	}
+
+	remaining := lastVerifiedAt.Add(p.MaxStaleness).Sub(now).Truncate(time.Second)
+	if remaining < time.Second {
+		logg.Error("not extending lifetime of credential %q in Memcache: last verification in Keystone was %s ago",
+			cred.String(), now.Sub(lastVerifiedAt).Truncate(time.Second))
+		p.setStale(cred, false, now)
+		return nil
+	}
+
+	ttl := min(expiry, remaining)
+	var found bool
+	if conservative {
+		// like in refreshConservatively(), make sure that we only extend exactly the cache entry that we inspected
+		cached, err := GetCachedCredentialFromMemcache(p.Memcache, cred)
+		if err != nil {
+			return err
+		}
+		if cached != nil && !cached.Payload.EqualTo(lastWrittenPayload) {
+			p.setStale(cred, false, now)
+			return errConservativeConflict
+		}
+		if cached != nil {
+			err = CompareAndSwapCredentialInMemcache(p.Memcache, *cached, *cached.Payload, ttl)
+			switch {
+			case errors.Is(err, memcache.ErrCacheMiss):
+				// the cache entry was deleted or has expired since we inspected it
+			case isCASConflict(err):
+				casConflictsCounter.With(cred.AsLabels()).Inc()
+				return err
+			case err != nil:
+				return err
+			default:
+				found = true
+			}
+		}
+	} else {
+		var err error
+		found, err = TouchCredentialInMemcache(p.Memcache, cred, ttl)
+		if err != nil {
+			return err
+		}
+	}
+	if !found {
+		logg.Error("cannot extend lifetime of credential %q in Memcache: cache entry does not exist anymore", cred.String())
+		p.setStale(cred, false, now)
+		return nil
+	}
+	logg.Info("extended lifetime of credential %q in Memcache by %s because Keystone is unavailable (last verified %s ago)",
+		cred.String(), ttl, now.Sub(lastVerifiedAt).Truncate(time.Second))
+	p.setStale(cred, true, now)
+	return nil
+}
+
+func (p *Prewarmer) setStale(cred CredentialID, stale bool, now time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	status, exists := p.statuses[cred]
+	if exists {
+		p.setStaleLocked(cred, status, stale, now)
+	}
+}
+
+func (p *Prewarmer) setStaleLocked(cred CredentialID, status *CredentialStatus, stale bool, now time.Time) {
+	status.Stale = stale
+	if stale {
+		staleSecsGauge.With(cred.AsLabels()).Set(now.Sub(status.LastSuccessAt).Seconds())
+	} else {
+		staleSecsGauge.Delete(cred.AsLabels())
+	}
+	p.countStaleCredentialsLocked()
+}
+
+func (p *Prewarmer) countStaleCredentialsLocked() {
+	count := 0
+	for _, status := range p.statuses {
+		if status.Stale {
+			count++
+		}
+	}
+	staleCredentialsGauge.Set(float64(count))
+}
+
+// refresh writes the payload into Memcache. If the cache entry has not
+// changed, only its expiration time is updated.
+func (p *Prewarmer) refresh(cred CredentialID, payload CredentialPayload, expiry time.Duration) (action string, err error) {
+	// compare with what is currently in Memcache (a corrupted cache entry is overwritten)
+	cachedPayload, err := GetCredentialFromMemcache(p.Memcache, cred)
+	isCorrupted := errors.Is(err, ErrDecode)
+	if err != nil && !isCorrupted {
+		return "", err
+	}
+
+	// if the payload has not changed, just update the expiration time
+	if cachedPayload != nil && cachedPayload.EqualTo(&payload) {
+		if p.Memcache.Replicas > 1 {
+			// we only inspected the cache entry on the first server, so
+			// replicas that were lost or are outdated need to be rewritten
+			rewritten, err := TouchOrRewriteReplicasInMemcache(p.Memcache, cred, payload, expiry)
+			if err != nil {
+				return "", err
+			}
+			if rewritten {
+				return "rewrite", nil
+			}
+			return "touch", nil
+		}
+		found, err := TouchCredentialInMemcache(p.Memcache, cred, expiry)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return "touch", nil
+		}
+		// otherwise the cache entry has expired or was evicted since we read it
+		cachedPayload = nil
+	}
+
+	// otherwise write the new payload into Memcache
+	action = "rewrite"
+	if cachedPayload == nil && !isCorrupted {
+		action = "create"
+	}
+	return action, SetCredentialInMemcache(p.Memcache, cred, payload, expiry)
+}
+
+// How often refreshConservatively() tries to update a cache entry before
+// giving up, and how long it waits between attempts (multiplied by the
+// number of attempts so far).
+const (
+	conservativeMaxAttempts = 3
+	conservativeRetryDelay  = 50 * time.Millisecond
+)
+
+// refreshConservatively is like refresh, but never overwrites a cache entry
+// that differs from the payload. To avoid overwriting a cache entry that was
+// changed by someone else after we inspected it, the cache entry is replaced
+// with memcached's compare-and-swap operation (or the add operation if it did
+// not exist). If someone else wrote into the cache entry in the meantime, the
+// whole procedure is retried.
+func (p *Prewarmer) refreshConservatively(ctx context.Context, cred CredentialID, payload CredentialPayload, expiry time.Duration) (action string, err error) {
+	for attempt := 1; ; attempt++ {
+		action, err = p.tryRefreshConservatively(cred, payload, expiry)
+		if !isCASConflict(err) {
+			return action, err
+		}
+		casConflictsCounter.With(cred.AsLabels()).Inc()
+		if attempt >= conservativeMaxAttempts {
+			return "", err
+		}
+		logg.Info("cache entry of credential %q was changed concurrently, retrying", cred.String())
+
+		select {
+		case <-ctx.Done():
+			return "", err
+		case <-time.After(time.Duration(attempt) * conservativeRetryDelay):
+		}
+	}
+}
+
+func (p *Prewarmer) tryRefreshConservatively(cred CredentialID, payload CredentialPayload, expiry time.Duration) (action string, err error) {
+	cached, err := GetCachedCredentialFromMemcache(p.Memcache, cred)
+	if err != nil {
+		return "", err
+	}
+	if cached == nil {
+		// Accept a not yet cached credential in conservative mode to get it into the cache
+		return "create", AddCredentialToMemcache(p.Memcache, cred, payload, expiry)
+	}
+	if !cached.Payload.EqualTo(&payload) {
+		return "", errConservativeConflict
+	}
+	// the payload has not changed, so this only updates the expiration time
+	return "touch", CompareAndSwapCredentialInMemcache(p.Memcache, *cached, *cached.Payload, expiry)
+}
+
+// isCASConflict checks whether a write by AddCredentialToMemcache() or
+// CompareAndSwapCredentialInMemcache() failed because someone else wrote into
+// (or deleted) the cache entry after we inspected it.
+func isCASConflict(err error) bool {
+	return errors.Is(err, memcache.ErrCASConflict) || // cas: entry was changed
+		errors.Is(err, memcache.ErrCacheMiss) || // cas: entry was deleted or has expired
+		errors.Is(err, memcache.ErrNotStored) // add: entry was created
+}
+
+func (p *Prewarmer) rememberWrittenPayload(cred CredentialID, payload *CredentialPayload) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	status, exists := p.statuses[cred]
+	if exists {
+		status.lastWrittenPayload = payload
+	}
+}
+
+// evictCredential deletes the cache entry for a credential that Keystone
+// does not accept anymore, so that its revocation becomes effective in Swift
+// immediately instead of after the cache entry expires.
+func (p *Prewarmer) evictCredential(cred CredentialID, conservative bool, keystoneErr error) error {
+	if conservative {
+		// only delete what we wrote ourselves; the entry may have been written
+		// by Swift (or by someone else) based on a different view of Keystone
+		cachedPayload, err := GetCredentialFromMemcache(p.Memcache, cred)
+		if err != nil {
+			return err
+		}
+		if cachedPayload == nil {
+			return nil
+		}
+		p.mutex.Lock()
+		var lastWrittenPayload *CredentialPayload
+		if status, exists := p.statuses[cred]; exists {
+			lastWrittenPayload = status.lastWrittenPayload
+		}
+		p.mutex.Unlock()
+		if lastWrittenPayload == nil || !cachedPayload.EqualTo(lastWrittenPayload) {
+			logg.Info("not evicting credential %q from Memcache: cache entry was not written by us", cred.String())
+			return nil
+		}
+	}
+
+	deleted, err := DeleteCredentialFromMemcache(p.Memcache, cred)
+	if err != nil || !deleted {
+		return err
+	}
+
+	reason := "unauthorized"
+	if errors.Is(keystoneErr, ErrNotFound) {
+		reason = "not_found"
+	}
+	evictionsCounter.With(withLabels(cred.AsLabels(), "reason", reason)).Inc()
+	logg.Info("evicted credential %q from Memcache (reason: %s)", cred.String(), reason)
+	return nil
+}
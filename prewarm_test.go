@@ -0,0 +1,699 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// newTestPrewarmer returns a Prewarmer that works against a fake Keystone and
+// a fake memcached, and prewarms the given credentials (all of which exist in
+// the fake Keystone with the secret "secret").
+func newTestPrewarmer(t *testing.T, creds ...CredentialID) (*Prewarmer, *fakeKeystone, *fakeMemcached) {
+	t.Helper()
+	fk, identityV3 := newFakeKeystone(t)
+	fm := newFakeMemcached(t)
+	p := &Prewarmer{
+		Keystone: &KeystoneClient{IdentityV3: identityV3},
+		Memcache: fm.Client(),
+		Expiry:   10 * time.Minute,
+	}
+	var cfgs []CredentialConfig
+	for _, cred := range creds {
+		fk.Credentials[cred] = "secret"
+		cfgs = append(cfgs, CredentialConfig{ID: cred})
+	}
+	p.SetConfiguredCredentials(cfgs)
+	return p, fk, fm
+}
+
+func TestEviction(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "key1"}
+
+	// without --evict, revoked credentials stay in the cache
+	p, fk, fm := newTestPrewarmer(t, cred)
+	p.doPrewarmCycle(t.Context(), 0)
+	delete(fk.Credentials, cred)
+	p.doPrewarmCycle(t.Context(), 0)
+	if _, exists := fm.Get(cred.CacheKey()); !exists {
+		t.Error("expected credential to stay in Memcache without --evict")
+	}
+
+	// with --evict, a deleted credential is evicted
+	p, fk, fm = newTestPrewarmer(t, cred)
+	p.Evict = true
+	p.doPrewarmCycle(t.Context(), 0)
+	delete(fk.Credentials, cred)
+	p.doPrewarmCycle(t.Context(), 0)
+	if _, exists := fm.Get(cred.CacheKey()); exists {
+		t.Error("expected deleted credential to be evicted from Memcache")
+	}
+
+	// same for a credential that cannot login anymore
+	p, fk, fm = newTestPrewarmer(t, cred)
+	p.Evict = true
+	p.doPrewarmCycle(t.Context(), 0)
+	fk.LoginStatus = http.StatusUnauthorized
+	p.doPrewarmCycle(t.Context(), 0)
+	if _, exists := fm.Get(cred.CacheKey()); exists {
+		t.Error("expected unauthorized credential to be evicted from Memcache")
+	}
+
+	// transient errors do not cause eviction
+	p, fk, fm = newTestPrewarmer(t, cred)
+	p.Evict = true
+	p.doPrewarmCycle(t.Context(), 0)
+	fk.LookupStatus = http.StatusServiceUnavailable
+	p.doPrewarmCycle(t.Context(), 0)
+	if _, exists := fm.Get(cred.CacheKey()); !exists {
+		t.Error("expected credential to stay in Memcache after transient Keystone error")
+	}
+}
+
+func TestEvictionInConservativeMode(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "key1"}
+
+	// a cache entry that we wrote ourselves is evicted
+	p, fk, fm := newTestPrewarmer(t, cred)
+	p.Evict = true
+	p.Conservative = true
+	p.doPrewarmCycle(t.Context(), 0)
+	delete(fk.Credentials, cred)
+	p.doPrewarmCycle(t.Context(), 0)
+	if _, exists := fm.Get(cred.CacheKey()); exists {
+		t.Error("expected deleted credential to be evicted from Memcache")
+	}
+
+	// a cache entry that was changed by someone else is not evicted
+	p, fk, fm = newTestPrewarmer(t, cred)
+	p.Evict = true
+	p.Conservative = true
+	p.doPrewarmCycle(t.Context(), 0)
+	fm.Set(cred.CacheKey(), []byte(`[{"X-User-Id":"uid1"},{"id":"project2"},"other-secret"]`))
+	delete(fk.Credentials, cred)
+	p.doPrewarmCycle(t.Context(), 0)
+	if _, exists := fm.Get(cred.CacheKey()); !exists {
+		t.Error("expected foreign cache entry to stay in Memcache in conservative mode")
+	}
+}
+
+func TestPrewarmCycleContinuesAfterFailure(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	cred3 := CredentialID{UserID: "uid3", AccessKey: "key3"}
+	p, fk, fm := newTestPrewarmer(t, cred1, cred2, cred3)
+	delete(fk.Credentials, cred2)
+
+	// a failure for one credential does not stop the prewarm of the others
+	p.doPrewarmCycle(t.Context(), 0)
+	for _, cred := range []CredentialID{cred1, cred3} {
+		if _, exists := fm.Get(cred.CacheKey()); !exists {
+			t.Errorf("expected credential %q to be prewarmed", cred.String())
+		}
+	}
+	if _, exists := fm.Get(cred2.CacheKey()); exists {
+		t.Errorf("expected credential %q to not be prewarmed", cred2.String())
+	}
+
+	// the failure is recorded with its category
+	status, _ := p.Status(cred2)
+	if !errors.Is(status.LastError, ErrNotFound) {
+		t.Errorf("expected ErrNotFound to be recorded for %q, but got %v", cred2.String(), status.LastError)
+	}
+}
+
+func TestPrewarmConcurrency(t *testing.T) {
+	var creds []CredentialID
+	for idx := range 8 {
+		creds = append(creds, CredentialID{UserID: "uid", AccessKey: fmt.Sprintf("key%d", idx)})
+	}
+	p, fk, fm := newTestPrewarmer(t, creds...)
+	p.Concurrency = 3
+	fk.LoginDelay = 20 * time.Millisecond
+
+	p.doPrewarmCycle(t.Context(), 0)
+	if fk.MaxConcurrentLogins != 3 {
+		t.Errorf("expected 3 concurrent logins, but got %d", fk.MaxConcurrentLogins)
+	}
+	for _, cred := range creds {
+		if _, exists := fm.Get(cred.CacheKey()); !exists {
+			t.Errorf("expected credential %q to be prewarmed", cred.String())
+		}
+	}
+}
+
+func TestPrewarmCycleOverrun(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid", AccessKey: "key2"}
+	cred3 := CredentialID{UserID: "uid", AccessKey: "key3"}
+	p, fk, fm := newTestPrewarmer(t, cred1, cred2, cred3)
+	p.Concurrency = 1
+	p.RequestTimeout = time.Second
+	fk.LoginDelay = 100 * time.Millisecond
+
+	// the deadline allows for only one credential to be prewarmed...
+	ctx, cancel := context.WithTimeout(t.Context(), 150*time.Millisecond)
+	defer cancel()
+	p.doPrewarmCycle(ctx, 0)
+	if _, exists := fm.Get(cred1.CacheKey()); !exists {
+		t.Errorf("expected credential %q to be prewarmed", cred1.String())
+	}
+	for _, cred := range []CredentialID{cred2, cred3} {
+		if _, exists := fm.Get(cred.CacheKey()); exists {
+			t.Errorf("expected credential %q to be skipped", cred.String())
+		}
+	}
+
+	// ...and the next cycle starts with the credential that was not even
+	// attempted in the last cycle
+	expected := []CredentialID{cred3, cred1, cred2}
+	actual := p.credentialsByLastAttempt()
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected next cycle to go in order %v, but got %v", expected, actual)
+	}
+
+	// the request timeout applies to each credential individually
+	p.RequestTimeout = 50 * time.Millisecond
+	p.doPrewarmCycle(t.Context(), 0)
+	status, _ := p.Status(cred1)
+	if !errors.Is(status.LastError, ErrTransient) {
+		t.Errorf("expected prewarm to fail with a transient error because of the request timeout, but got %v", status.LastError)
+	}
+}
+
+func TestPrewarmCycleIsSpreadOut(t *testing.T) {
+	var creds []CredentialID
+	for idx := range 4 {
+		creds = append(creds, CredentialID{UserID: "uid", AccessKey: fmt.Sprintf("key%d", idx)})
+	}
+	p, _, _ := newTestPrewarmer(t, creds...)
+
+	// with a cycle length of 400ms, the last refresh happens at least 225ms into the cycle
+	start := time.Now()
+	p.doPrewarmCycle(t.Context(), 400*time.Millisecond)
+	duration := time.Since(start)
+	if duration < 225*time.Millisecond {
+		t.Errorf("expected prewarm cycle to take at least 225ms, but took %s", duration)
+	}
+
+	// each credential has been rescheduled for the next cycle
+	for _, cred := range creds {
+		status, _ := p.Status(cred)
+		if status.NextRefreshAt.Before(start.Add(400*time.Millisecond)) || status.NextRefreshAt.After(start.Add(700*time.Millisecond)) {
+			t.Errorf("expected next refresh of %q to be scheduled in the next cycle, but got %s (cycle started at %s)",
+				cred.String(), status.NextRefreshAt, start)
+		}
+	}
+}
+
+func TestNextRefreshAfterImmediatePrewarm(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	p, _, _ := newTestPrewarmer(t, cred1)
+	p.doPrewarmCycle(t.Context(), 0)
+	p.mutex.Lock()
+	p.cycleLength = time.Minute
+	p.mutex.Unlock()
+
+	// an immediate prewarm does not move a refresh that is still upcoming in the current cycle...
+	p.recordSchedule([]scheduledRefresh{{cred1, time.Now().Add(30 * time.Second)}})
+	status, _ := p.Status(cred1)
+	expectedNextRefreshAt := status.NextRefreshAt
+	// ...but for a new credential, an estimate in the future is recorded
+	p.SetConfiguredCredentials([]CredentialConfig{{ID: cred1}, {ID: cred2}})
+	start := time.Now()
+	p.prewarmCredentials(t.Context(), scheduleRefreshes([]CredentialID{cred1, cred2}, start, 0), 0)
+
+	status, _ = p.Status(cred1)
+	if !status.NextRefreshAt.Equal(expectedNextRefreshAt) {
+		t.Errorf("expected next refresh of %q to stay at %s, but got %s", cred1.String(), expectedNextRefreshAt, status.NextRefreshAt)
+	}
+	status, _ = p.Status(cred2)
+	if status.NextRefreshAt.Before(start.Add(time.Minute)) || status.NextRefreshAt.After(time.Now().Add(time.Minute)) {
+		t.Errorf("expected next refresh of %q to be estimated one cycle length ahead, but got %s (prewarm started at %s)",
+			cred2.String(), status.NextRefreshAt, start)
+	}
+	expected := float64(status.NextRefreshAt.Unix())
+	if value := getMetricValue(t, nextRefreshSecsGauge.With(cred2.AsLabels())); value != expected {
+		t.Errorf("expected next refresh metric of %q to be %g, but got %g", cred2.String(), expected, value)
+	}
+}
+
+func TestImmediatePrewarmDuringCycle(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	cred3 := CredentialID{UserID: "uid3", AccessKey: "key3"}
+	p, fk, fm := newTestPrewarmer(t, cred1, cred2)
+	fk.Credentials[cred3] = "secret"
+
+	ctx, cancel := context.WithCancel(t.Context())
+	reloaded := make(chan []CredentialID)
+	moved := make(chan []CredentialID)
+	triggered := make(chan []CredentialID)
+	done := make(chan struct{})
+	go func() {
+		runPrewarmLoop(ctx, p, reloaded, moved, triggered)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	expectCachedSoon := func(cred CredentialID) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, exists := fm.Get(cred.CacheKey()); exists {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("expected %q to be prewarmed immediately", cred.String())
+	}
+
+	// the first cycle starts right away, but spreads its refreshes over most of the cycle length
+	for {
+		if startedAt, _, _ := p.CycleStatus(); !startedAt.IsZero() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// reloads and triggers are handled while that cycle is still running
+	// (cred3 is not part of the running cycle, so only an immediate prewarm can put it into the cache)
+	added, _ := p.SetConfiguredCredentials([]CredentialConfig{{ID: cred1}, {ID: cred2}, {ID: cred3}})
+	reloaded <- added
+	expectCachedSoon(cred3)
+	fm.Flush()
+	triggered <- []CredentialID{cred3}
+	expectCachedSoon(cred3)
+
+	if _, _, completedCycles := p.CycleStatus(); completedCycles != 0 {
+		t.Errorf("expected first cycle to still be running, but %d cycles were completed", completedCycles)
+	}
+}
+
+func TestExtendLifetimeDuringKeystoneOutage(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	p, fk, fm := newTestPrewarmer(t, cred)
+	p.MaxStaleness = time.Hour
+	p.doPrewarmCycle(t.Context(), 0)
+
+	// overwrite the cache entry with the same payload, but without expiration
+	// time, so that we can see when it gets touched
+	resetExpiration := func() {
+		item, _ := fm.Get(cred.CacheKey())
+		fm.Set(cred.CacheKey(), item.Value)
+	}
+	expectExpiration := func(expected int32) {
+		t.Helper()
+		item, _ := fm.Get(cred.CacheKey())
+		if item.Expiration != expected {
+			t.Errorf("expected cache entry to have expiration %d, but got %d", expected, item.Expiration)
+		}
+	}
+	expectStale := func(expected bool) {
+		t.Helper()
+		status, _ := p.Status(cred)
+		if status.Stale != expected {
+			t.Errorf("expected credential to have Stale = %t, but got %t", expected, status.Stale)
+		}
+	}
+
+	// when Keystone is down, the cache entry is touched
+	resetExpiration()
+	fk.LookupStatus = http.StatusServiceUnavailable
+	p.doPrewarmCycle(t.Context(), 0)
+	expectExpiration(600)
+	expectStale(true)
+
+	// when Keystone is back, the credential is not stale anymore
+	fk.LookupStatus = 0
+	p.doPrewarmCycle(t.Context(), 0)
+	expectStale(false)
+
+	// non-transient errors do not cause the cache entry to be touched
+	resetExpiration()
+	fk.LookupStatus = http.StatusNotFound
+	p.doPrewarmCycle(t.Context(), 0)
+	expectExpiration(0)
+	expectStale(false)
+
+	// the lifetime is not extended beyond the maximum staleness...
+	resetExpiration()
+	fk.LookupStatus = http.StatusServiceUnavailable
+	p.MaxStaleness = 90 * time.Second
+	p.doPrewarmCycle(t.Context(), 0)
+	expectExpiration(89)
+	expectStale(true)
+
+	// ...and not at all once the maximum staleness is exceeded
+	resetExpiration()
+	p.MaxStaleness = 500 * time.Millisecond
+	p.doPrewarmCycle(t.Context(), 0)
+	expectExpiration(0)
+	expectStale(false)
+}
+
+func TestExtendLifetimeInConservativeMode(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "stalekey"}
+	p, fk, fm := newTestPrewarmer(t, cred)
+	p.MaxStaleness = time.Hour
+	p.Conservative = true
+	p.doPrewarmCycle(t.Context(), 0)
+
+	// when Keystone is down, the cache entry is extended with compare-and-swap
+	fk.LookupStatus = http.StatusServiceUnavailable
+	p.doPrewarmCycle(t.Context(), 0)
+	status, _ := p.Status(cred)
+	if !status.Stale {
+		t.Error("expected credential to be stale")
+	}
+
+	// if the cache entry disappears between reading and extending it, this is
+	// not a Memcache error, but there is nothing left to extend
+	fm.InterfereByDeleting("cas", 1)
+	p.doPrewarmCycle(t.Context(), 0)
+	status, _ = p.Status(cred)
+	if status.Stale {
+		t.Error("expected credential to not be stale anymore")
+	}
+	if status.LastFailureReason != "keystone_error" || errors.Is(status.LastError, memcache.ErrCacheMiss) {
+		t.Errorf("expected only the Keystone error to be reported, but got %q: %v", status.LastFailureReason, status.LastError)
+	}
+}
+
+func TestRefreshOnlyTouchesUnchangedPayload(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "touchkey"}
+	p, fk, fm := newTestPrewarmer(t, cred)
+	expectAction := func(expected string) {
+		t.Helper()
+		labels := withLabels(cred.AsLabels(), "action", expected)
+		before := getMetricValue(t, refreshesCounter.With(labels))
+		p.doPrewarmCycle(t.Context(), 0)
+		if getMetricValue(t, refreshesCounter.With(labels)) != before+1 {
+			t.Errorf("expected prewarm to count as %q", expected)
+		}
+	}
+
+	// first prewarm creates the cache entry
+	expectAction("create")
+	item1, _ := fm.Get(cred.CacheKey())
+
+	// second prewarm only touches the cache entry (which does not change its CAS ID)
+	expectAction("touch")
+	item2, _ := fm.Get(cred.CacheKey())
+	if item2.CAS != item1.CAS {
+		t.Error("expected unchanged cache entry to only be touched, but it was rewritten")
+	}
+
+	// when the credential changes in Keystone, the cache entry is rewritten
+	fk.Credentials[cred] = "newsecret"
+	expectAction("rewrite")
+	payload, err := GetCredentialFromMemcache(p.Memcache, cred)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if payload.Secret != "newsecret" {
+		t.Errorf("expected cache entry to be rewritten with the new secret, but got %q", payload.Secret)
+	}
+
+	// a corrupted cache entry is rewritten
+	fm.Set(cred.CacheKey(), []byte("garbage"))
+	expectAction("rewrite")
+	_, err = GetCredentialFromMemcache(p.Memcache, cred)
+	if err != nil {
+		t.Errorf("expected corrupted cache entry to be rewritten, but got: %s", err.Error())
+	}
+}
+
+func TestConservativeModeUsesCompareAndSwap(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "caskey"}
+	p, _, fm := newTestPrewarmer(t, cred)
+	p.Conservative = true
+	conflictsBefore := getMetricValue(t, casConflictsCounter.With(cred.AsLabels()))
+	expectConflicts := func(expected float64) {
+		t.Helper()
+		actual := getMetricValue(t, casConflictsCounter.With(cred.AsLabels())) - conflictsBefore
+		if actual != expected {
+			t.Errorf("expected %g CAS conflicts, but got %g", expected, actual)
+		}
+	}
+	expectError := func(expected error) {
+		t.Helper()
+		status, _ := p.Status(cred)
+		if !errors.Is(status.LastError, expected) {
+			t.Errorf("expected last error to be %v, but got %v", expected, status.LastError)
+		}
+	}
+
+	// if someone else creates the cache entry while we try to, we inspect it
+	// again (and since it is identical, we accept it)
+	fm.Interfere("add", 1)
+	p.doPrewarmCycle(t.Context(), 0)
+	expectError(nil)
+	expectConflicts(1)
+	if _, exists := fm.Get(cred.CacheKey()); !exists {
+		t.Error("expected credential to be prewarmed")
+	}
+
+	// if someone else keeps changing the cache entry while we try to update
+	// it, we give up eventually
+	fm.Interfere("cas", conservativeMaxAttempts)
+	p.doPrewarmCycle(t.Context(), 0)
+	expectError(memcache.ErrCASConflict)
+	expectConflicts(1 + conservativeMaxAttempts)
+
+	// without interference, the update succeeds
+	p.doPrewarmCycle(t.Context(), 0)
+	expectError(nil)
+	expectConflicts(1 + conservativeMaxAttempts)
+
+	// if someone else deletes the cache entry (or it expires) while we try to
+	// update it, we inspect it again and create it anew
+	fm.InterfereByDeleting("cas", 1)
+	p.doPrewarmCycle(t.Context(), 0)
+	expectError(nil)
+	expectConflicts(2 + conservativeMaxAttempts)
+	if _, exists := fm.Get(cred.CacheKey()); !exists {
+		t.Error("expected credential to be prewarmed again")
+	}
+
+	// a cache entry that differs from Keystone is not touched at all
+	fm.Set(cred.CacheKey(), []byte(`[{"X-User-Id":"uid1"},{"id":"project2"},"other-secret"]`))
+	p.doPrewarmCycle(t.Context(), 0)
+	expectError(errConservativeConflict)
+}
+
+func getMetricValue(t *testing.T, metric prometheus.Metric) float64 {
+	t.Helper()
+	var m dto.Metric
+	err := metric.Write(&m)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	switch {
+	case m.Counter != nil:
+		return m.Counter.GetValue()
+	case m.Gauge != nil:
+		return m.Gauge.GetValue()
+	default:
+		t.Fatalf("cannot read value of metric %v", metric.Desc())
+		return 0
+	}
+}
+
+func TestFailureReasons(t *testing.T) {
+	cred := CredentialID{UserID: "uid1", AccessKey: "failkey"}
+	p, fk, fm := newTestPrewarmer(t, cred)
+	expectFailure := func(expectedReason string) {
+		t.Helper()
+		attemptsBefore := getMetricValue(t, attemptsCounter.With(cred.AsLabels()))
+		failuresBefore := make(map[string]float64)
+		for _, reason := range []string{"keystone_not_found", "keystone_unauthorized", "keystone_error", "memcache_error", "conservative_conflict"} {
+			failuresBefore[reason] = getMetricValue(t, failuresCounter.With(withLabels(cred.AsLabels(), "reason", reason)))
+		}
+
+		p.doPrewarmCycle(t.Context(), 0)
+
+		if getMetricValue(t, attemptsCounter.With(cred.AsLabels())) != attemptsBefore+1 {
+			t.Error("expected prewarm to count as an attempt")
+		}
+		for reason, before := range failuresBefore {
+			expected := before
+			if reason == expectedReason {
+				expected++
+			}
+			actual := getMetricValue(t, failuresCounter.With(withLabels(cred.AsLabels(), "reason", reason)))
+			if actual != expected {
+				t.Errorf("expected %g failures with reason %q, but got %g", expected, reason, actual)
+			}
+		}
+	}
+
+	expectFailure("") // success
+	fk.LookupStatus = http.StatusNotFound
+	expectFailure("keystone_not_found")
+	fk.LookupStatus = http.StatusServiceUnavailable
+	expectFailure("keystone_error")
+	fk.LookupStatus = 0
+	fk.LoginStatus = http.StatusUnauthorized
+	expectFailure("keystone_unauthorized")
+	fk.LoginStatus = 0
+
+	p.Conservative = true
+	fm.Set(cred.CacheKey(), []byte(`[{"X-User-Id":"uid1"},{"id":"project2"},"other-secret"]`))
+	expectFailure("conservative_conflict")
+	p.Conservative = false
+
+	// (a new client is needed, since the old one would reuse its idle connection)
+	fm.Listener.Close()
+	p.Memcache = MustConnectToMemcache([]string{fm.Address()}, MemcacheOptions{})
+	expectFailure("memcache_error")
+}
+
+func TestResults(t *testing.T) {
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	p, fk, _ := newTestPrewarmer(t, cred1, cred2)
+	delete(fk.Credentials, cred2)
+
+	// before the first cycle, all credentials count as failed
+	for _, result := range p.Results() {
+		if result.Success || result.Error == "" {
+			t.Errorf("expected credential to count as failed before the first cycle, but got %#v", result)
+		}
+	}
+
+	p.doPrewarmCycle(t.Context(), 0)
+	results := p.Results()
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, but got %#v", results)
+	}
+	if !results[0].Success || results[0].Error != "" {
+		t.Errorf("expected success for %s, but got %#v", cred1, results[0])
+	}
+	if results[1].Success || results[1].FailureReason != "keystone_not_found" {
+		t.Errorf("expected keystone_not_found for %s, but got %#v", cred2, results[1])
+	}
+}
+
+func TestReplication(t *testing.T) {
+	var creds []CredentialID
+	for idx := range 10 {
+		creds = append(creds, CredentialID{UserID: fmt.Sprintf("uid%d", idx), AccessKey: fmt.Sprintf("key%d", idx)})
+	}
+	p, _, fm1 := newTestPrewarmer(t, creds...)
+	fms := map[string]*fakeMemcached{fm1.Address(): fm1}
+	for range 2 {
+		fm := newFakeMemcached(t)
+		fms[fm.Address()] = fm
+	}
+	var err error
+	p.Memcache, err = NewMemcacheClient(slices.Collect(maps.Keys(fms)), MemcacheOptions{Replicas: 2})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// each credential is written to the first two servers in the failover order
+	expectReplicas := func() {
+		t.Helper()
+		for _, cred := range creds {
+			placement := p.Memcache.Ring.Placement(cred.CacheKey())
+			for idx, server := range placement {
+				_, exists := fms[server].Get(cred.CacheKey())
+				if exists != (idx < 2) {
+					t.Errorf("expected credential %q to exist on server #%d = %t, but got %t", cred.String(), idx, idx < 2, exists)
+				}
+			}
+		}
+	}
+	// (the refresh counter is shared with other tests, so we look at how it changes during each cycle)
+	countActions := func() map[string]float64 {
+		result := make(map[string]float64)
+		for _, action := range []string{"create", "rewrite", "touch"} {
+			for _, cred := range creds {
+				result[action] += getMetricValue(t, refreshesCounter.With(withLabels(cred.AsLabels(), "action", action)))
+			}
+		}
+		return result
+	}
+	doPrewarmCycle := func(expectedActions map[string]float64) {
+		t.Helper()
+		before := countActions()
+		p.doPrewarmCycle(t.Context(), 0)
+		after := countActions()
+		for action, countBefore := range before {
+			if after[action]-countBefore != expectedActions[action] {
+				t.Errorf("expected %g refreshes with action %q, but got %g", expectedActions[action], action, after[action]-countBefore)
+			}
+		}
+	}
+	doPrewarmCycle(map[string]float64{"create": 10})
+	expectReplicas()
+	for server := range fms {
+		value := getMetricValue(t, memcacheReplicaWritesCounter.WithLabelValues(server, "success"))
+		if value == 0 {
+			t.Errorf("expected successful writes to be counted for server %s", server)
+		}
+	}
+
+	// when a server loses its contents, the next refresh restores them, even
+	// if the first replica still has the unchanged payload
+	for _, fm := range fms {
+		if fm != fm1 {
+			fm.Flush()
+		}
+	}
+	restoredCount := 0.0
+	for _, cred := range creds {
+		// only credentials whose first server is fm1 are found to be unchanged
+		// (for all others, the first server was flushed, so they are recreated)
+		if p.Memcache.Ring.Placement(cred.CacheKey())[0] == fm1.Address() {
+			restoredCount++
+		}
+	}
+	doPrewarmCycle(map[string]float64{"rewrite": restoredCount, "create": 10 - restoredCount})
+	expectReplicas()
+
+	// when all replicas are intact, only the expiration time is updated
+	doPrewarmCycle(map[string]float64{"touch": 10})
+	expectReplicas()
+
+	// a replica with an outdated payload is rewritten instead of being kept alive
+	placement := p.Memcache.Ring.Placement(creds[1].CacheKey())
+	expected, _ := fms[placement[0]].Get(creds[1].CacheKey())
+	fms[placement[1]].Set(creds[1].CacheKey(), []byte(`[{"X-User-Id":"uid1"},{"id":"project2"},"other-secret"]`))
+	doPrewarmCycle(map[string]float64{"touch": 9, "rewrite": 1})
+	if actual, _ := fms[placement[1]].Get(creds[1].CacheKey()); !bytes.Equal(actual.Value, expected.Value) {
+		t.Errorf("expected outdated replica to be rewritten with %q, but got %q", expected.Value, actual.Value)
+	}
+
+	// evictions remove all replicas
+	deleted, err := DeleteCredentialFromMemcache(p.Memcache, creds[0])
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !deleted {
+		t.Error("expected credential to be deleted")
+	}
+	for server, fm := range fms {
+		if _, exists := fm.Get(creds[0].CacheKey()); exists {
+			t.Errorf("expected credential to be deleted from %s", server)
+		}
+	}
+}
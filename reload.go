@@ -0,0 +1,138 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/sapcc/go-bits/logg"
+)
+
+// How often the config file and credentials file are checked for changes.
+// Polling (instead of inotify) also works for Kubernetes ConfigMaps, which
+// are updated by swapping a symlink.
+const configPollInterval = 10 * time.Second
+
+// credentialSource describes where the prewarm command gets its configured
+// (i.e. not discovered) credentials from.
+type credentialSource struct {
+	ConfigPath      string // from --config (may be empty)
+	CredentialsPath string // from --credentials-file (may be empty)
+	Args            []CredentialID
+}
+
+// Load reads the config file and credentials file and returns the contents
+// of the config file, as well as the combined list of configured credentials.
+func (s credentialSource) Load() (Config, []CredentialConfig, error) {
+	var cfg Config
+	if s.ConfigPath != "" {
+		loaded, err := LoadConfig(s.ConfigPath)
+		if err != nil {
+			return Config{}, nil, err
+		}
+		cfg = *loaded
+	}
+	var fromFile []CredentialID
+	if s.CredentialsPath != "" {
+		var err error
+		fromFile, err = LoadCredentialsFile(s.CredentialsPath)
+		if err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	// credentials from the config file come first since they may carry
+	// per-credential settings
+	result := slices.Clone(cfg.Credentials)
+	for _, cred := range mergeCredentials(fromFile, s.Args) {
+		if !slices.ContainsFunc(result, func(cfg CredentialConfig) bool { return cfg.ID == cred }) {
+			result = append(result, CredentialConfig{ID: cred})
+		}
+	}
+	return cfg, result, nil
+}
+
+func (s credentialSource) files() []string {
+	var result []string
+	for _, path := range []string{s.ConfigPath, s.CredentialsPath} {
+		if path != "" {
+			result = append(result, path)
+		}
+	}
+	return result
+}
+
+// runReloadLoop reloads the configured credentials whenever SIGHUP is
+// received or one of the source files changes. After each successful reload,
+// the credentials that were added by it (if any) are sent into the `reloaded`
+// channel, so that they can be prewarmed immediately instead of waiting for
+// the next prewarm cycle, and so that the cycle length can be adjusted to
+// changed expiries.
+//
+// The `contents` argument must be the result of readFiles(source.files()),
+// taken before the credentials were loaded initially.
+func runReloadLoop(ctx context.Context, p *Prewarmer, source credentialSource, contents map[string][]byte, pollInterval time.Duration, reloaded chan<- []CredentialID) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	defer signal.Stop(sighup)
+
+	tick := time.Tick(pollInterval)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-sighup:
+			logg.Info("reloading credentials because SIGHUP was received")
+			contents = readFiles(source.files())
+		case <-tick:
+			newContents := readFiles(source.files())
+			changed := false
+			for path, buf := range newContents {
+				if !bytes.Equal(buf, contents[path]) {
+					logg.Info("reloading credentials because %s has changed", path)
+					changed = true
+				}
+			}
+			contents = newContents
+			if !changed {
+				continue
+			}
+		}
+
+		_, creds, err := source.Load()
+		if err != nil {
+			// keep prewarming the credentials that we know about
+			logg.Error("could not reload credentials: %s", err.Error())
+			continue
+		}
+		addedCreds, removedCreds := p.SetConfiguredCredentials(creds)
+		logg.Info("reload found %d new and %d removed credentials", len(addedCreds), len(removedCreds))
+		select {
+		case <-ctx.Done():
+			return
+		case reloaded <- addedCreds:
+		}
+	}
+}
+
+// readFiles returns the contents of the given files. Files that cannot be
+// read are reported with nil contents (the actual error will be reported by
+// credentialSource.Load()).
+func readFiles(paths []string) map[string][]byte {
+	result := make(map[string][]byte, len(paths))
+	for _, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			buf = nil
+		}
+		result[path] = buf
+	}
+	return result
+}
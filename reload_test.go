@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReloadOnFileChange(t *testing.T) {
+	dir := t.TempDir()
+	credsPath := filepath.Join(dir, "credentials")
+	configPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, credsPath, "# comment\nuid1:key1\n\nuid2:key2\n")
+	writeFile(t, configPath, "credentials: [ { credential: uid3:key3, expiry: 5m } ]")
+
+	cred1 := CredentialID{UserID: "uid1", AccessKey: "key1"}
+	cred2 := CredentialID{UserID: "uid2", AccessKey: "key2"}
+	cred3 := CredentialID{UserID: "uid3", AccessKey: "key3"}
+	cred4 := CredentialID{UserID: "uid4", AccessKey: "key4"}
+	argCred := CredentialID{UserID: "uid0", AccessKey: "key0"}
+	source := credentialSource{
+		ConfigPath:      configPath,
+		CredentialsPath: credsPath,
+		Args:            []CredentialID{argCred},
+	}
+
+	p := &Prewarmer{Expiry: 10 * time.Minute}
+	contents := readFiles(source.files())
+	_, creds, err := source.Load()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	p.SetConfiguredCredentials(creds)
+	expectCredentials(t, p, cred3, cred1, cred2, argCred)
+	if p.CycleLength() != time.Minute {
+		t.Errorf("expected cycle length to follow the shortest expiry, but got %s", p.CycleLength())
+	}
+
+	reloaded := make(chan []CredentialID)
+	go runReloadLoop(t.Context(), p, source, contents, 10*time.Millisecond, reloaded)
+
+	// changing a file adds and removes credentials
+	writeFile(t, credsPath, "uid2:key2\nuid4:key4\n")
+	select {
+	case addedCreds := <-reloaded:
+		if !slices.Equal(addedCreds, []CredentialID{cred4}) {
+			t.Errorf("expected reload to add %v, but got %v", []CredentialID{cred4}, addedCreds)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout while waiting for reload")
+	}
+	expectCredentials(t, p, cred3, cred2, cred4, argCred)
+
+	// an invalid file does not change the set of credentials
+	writeFile(t, configPath, "credentials: [ { credential: foo } ]")
+	time.Sleep(100 * time.Millisecond)
+	expectCredentials(t, p, cred3, cred2, cred4, argCred)
+}
+
+func TestReloadOnExpiryChange(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yaml")
+	writeFile(t, configPath, "credentials: [ { credential: uid1:key1, expiry: 10m } ]")
+	source := credentialSource{ConfigPath: configPath}
+
+	p := &Prewarmer{Expiry: 10 * time.Minute}
+	contents := readFiles(source.files())
+	_, creds, err := source.Load()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	p.SetConfiguredCredentials(creds)
+
+	reloaded := make(chan []CredentialID)
+	go runReloadLoop(t.Context(), p, source, contents, 10*time.Millisecond, reloaded)
+
+	// a reload that only changes settings is signaled as well, so that the cycle length can be adjusted
+	for _, expiry := range []time.Duration{time.Minute, 10 * time.Minute} {
+		writeFile(t, configPath, fmt.Sprintf("credentials: [ { credential: uid1:key1, expiry: %s } ]", expiry))
+		select {
+		case addedCreds := <-reloaded:
+			if len(addedCreds) != 0 {
+				t.Errorf("expected reload to add no credentials, but got %v", addedCreds)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout while waiting for reload")
+		}
+		if p.CycleLength() != expiry/5 {
+			t.Errorf("expected cycle length %s after reload, but got %s", expiry/5, p.CycleLength())
+		}
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	err := os.WriteFile(path, []byte(contents), 0o666)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func expectCredentials(t *testing.T, p *Prewarmer, expected ...CredentialID) {
+	t.Helper()
+	actual := p.Credentials()
+	if !slices.Equal(actual, expected) {
+		t.Errorf("expected credentials %v, but got %v", expected, actual)
+	}
+}
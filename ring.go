@@ -0,0 +1,303 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/md5" //nolint:gosec // used in a none security relevant way
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+const (
+	// These constants have the same names and values as in swift/common/memcached.py.
+	swiftDefaultMemcachedPort = 11211
+	swiftNodeWeight           = 50
+	swiftTryCount             = 3
+	swiftErrorLimitCount      = 10
+	swiftErrorLimitTime       = 60 * time.Second
+	swiftErrorLimitDuration   = 60 * time.Second
+)
+
+// MemcacheRing is a memcache.ServerSelector that distributes keys across
+// servers in exactly the same way as the MemcacheRing class in Swift's
+// swift/common/memcached.py. The default selector of gomemcache (CRC32 modulo
+// number of servers) would put our cache entries on different servers than
+// the ones where Swift looks for them.
+//
+// Servers are identified by the exact strings given in the configuration,
+// since Swift hashes those strings (and not the resolved addresses) to build
+// its ring.
+type MemcacheRing struct {
+	tries int
+
+	mutex        sync.RWMutex
+	servers      []string            // sorted, without duplicates
+	addrs        map[string]net.Addr // key = server string
+	ring         map[string]string   // key = md5 hash of ring position, value = server string
+	sortedHashes []string
+	// Swift skips servers that have recently failed too often ("error limiting").
+	errorTimes        map[string][]time.Time // key = server string
+	errorLimitedUntil map[string]time.Time   // key = server string
+}
+
+// NewMemcacheRing builds an empty MemcacheRing. Use SetServers to fill it.
+// The number of tries has the same meaning as Swift's "tries" option in
+// memcache.conf: at most that many servers are considered for each key.
+func NewMemcacheRing(tries int) *MemcacheRing {
+	if tries <= 0 {
+		tries = swiftTryCount
+	}
+	return &MemcacheRing{tries: tries}
+}
+
+// SetServers replaces the set of servers in this ring. The server strings
+// should be given exactly like in Swift's `memcache_servers` option, so that
+// the ring positions match those computed by Swift. The ring is replaced
+// atomically, and if any error is returned, no changes are made to the ring.
+func (r *MemcacheRing) SetServers(servers ...string) error {
+	servers = slices.Clone(servers)
+	slices.Sort(servers)
+	servers = slices.Compact(servers)
+
+	addrs := make(map[string]net.Addr, len(servers))
+	ring := make(map[string]string, len(servers)*swiftNodeWeight)
+	for _, server := range servers {
+		addr, err := parseMemcacheServer(server)
+		if err != nil {
+			return err
+		}
+		addrs[server] = addr
+		for idx := range swiftNodeWeight {
+			ring[swiftMD5Hash(fmt.Sprintf("%s-%d", server, idx))] = server
+		}
+	}
+	sortedHashes := make([]string, 0, len(ring))
+	for hash := range ring {
+		sortedHashes = append(sortedHashes, hash)
+	}
+	slices.Sort(sortedHashes)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	// servers that stay in the ring keep their error count and error limit
+	errorTimes := make(map[string][]time.Time, len(servers))
+	errorLimitedUntil := make(map[string]time.Time, len(servers))
+	for _, server := range servers {
+		if times, exists := r.errorTimes[server]; exists {
+			errorTimes[server] = times
+		}
+		if until, exists := r.errorLimitedUntil[server]; exists {
+			errorLimitedUntil[server] = until
+		}
+	}
+	r.servers = servers
+	r.addrs = addrs
+	r.ring = ring
+	r.sortedHashes = sortedHashes
+	r.errorTimes = errorTimes
+	r.errorLimitedUntil = errorLimitedUntil
+	return nil
+}
+
+// Servers returns the list of server strings in this ring, in sorted order.
+func (r *MemcacheRing) Servers() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return slices.Clone(r.servers)
+}
+
+// FailoverOrder returns the servers that Swift would try for the given key,
+// in the order in which Swift tries them. Servers which are currently
+// error-limited are not included in the result, but like in Swift, they
+// still count towards the number of tries.
+//
+// The key must be the key as sent to memcached (i.e. the result of
+// CredentialID.CacheKey()), since this is also what Swift uses to find the
+// ring position.
+func (r *MemcacheRing) FailoverOrder(key string) []net.Addr {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	now := time.Now()
+	var result []net.Addr
+	for _, server := range r.placementLocked(key) {
+		if r.errorLimitedUntil[server].After(now) {
+			continue
+		}
+		result = append(result, r.addrs[server])
+	}
+	return result
+}
+
+// Placement returns the server strings that Swift would try for the given key,
+// in the order in which Swift tries them, regardless of error limiting.
+func (r *MemcacheRing) Placement(key string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.placementLocked(key)
+}
+
+func (r *MemcacheRing) placementLocked(key string) []string {
+	if len(r.sortedHashes) == 0 {
+		return nil
+	}
+	tries := min(r.tries, len(r.servers))
+	result := make([]string, 0, tries)
+
+	// NOTE: This reproduces bisect.bisect_right() followed by incrementing
+	// `pos` before the first lookup, exactly as in MemcacheRing._get_conns().
+	pos, found := slices.BinarySearch(r.sortedHashes, key)
+	if found {
+		pos++
+	}
+	for len(result) < tries {
+		pos = (pos + 1) % len(r.sortedHashes)
+		server := r.ring[r.sortedHashes[pos]]
+		if !slices.Contains(result, server) {
+			result = append(result, server)
+		}
+	}
+	return result
+}
+
+// PickServer implements the memcache.ServerSelector interface.
+func (r *MemcacheRing) PickServer(key string) (net.Addr, error) {
+	addrs := r.FailoverOrder(key)
+	if len(addrs) == 0 {
+		return nil, memcache.ErrNoServers
+	}
+	return addrs[0], nil
+}
+
+// Each implements the memcache.ServerSelector interface.
+func (r *MemcacheRing) Each(action func(net.Addr) error) error {
+	r.mutex.RLock()
+	addrs := make([]net.Addr, len(r.servers))
+	for idx, server := range r.servers {
+		addrs[idx] = r.addrs[server]
+	}
+	r.mutex.RUnlock()
+
+	for _, addr := range addrs {
+		err := action(addr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrorOccurred records that a request to the given server failed. Like in
+// MemcacheRing._exception_occurred() in Swift, a server that failed more than
+// swiftErrorLimitCount times within swiftErrorLimitTime will not be selected
+// for swiftErrorLimitDuration. (Single failures do not need to be remembered,
+// since each request fails over to the next server on its own.)
+func (r *MemcacheRing) ErrorOccurred(addr net.Addr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	for server, serverAddr := range r.addrs {
+		if serverAddr.String() != addr.String() {
+			continue
+		}
+		times := append(r.errorTimes[server], now)
+		if len(times) > swiftErrorLimitCount {
+			times = slices.DeleteFunc(times, func(t time.Time) bool { return !t.After(now.Add(-swiftErrorLimitTime)) })
+			if len(times) > swiftErrorLimitCount {
+				r.errorLimitedUntil[server] = now.Add(swiftErrorLimitDuration)
+			}
+		}
+		r.errorTimes[server] = times
+	}
+}
+
+// Tries returns the maximum number of servers that are tried for each key.
+func (r *MemcacheRing) Tries() int {
+	return r.tries
+}
+
+// Like md5hash() in swift/common/memcached.py.
+func swiftMD5Hash(input string) string {
+	hashBytes := md5.Sum([]byte(input)) //nolint:gosec // input cannot be chosen by the user
+	return hex.EncodeToString(hashBytes[:])
+}
+
+// memcacheAddr is a net.Addr for a memcached server. Unlike net.TCPAddr, it
+// is not resolved, so that DNS changes are picked up when reconnecting.
+type memcacheAddr struct {
+	hostPort string
+}
+
+func (a memcacheAddr) Network() string { return "tcp" }
+func (a memcacheAddr) String() string  { return a.hostPort }
+
+// Like utils.parse_socket_string() in Swift, as used by MemcacheConnPool.
+func parseMemcacheServer(server string) (net.Addr, error) {
+	defaultPort := strconv.Itoa(swiftDefaultMemcachedPort)
+	var host, port string
+	switch {
+	case strings.HasPrefix(server, "["):
+		// IPv6 address in brackets, optionally with port
+		var found bool
+		host, port, found = strings.Cut(strings.TrimPrefix(server, "["), "]")
+		if !found {
+			return nil, fmt.Errorf("invalid memcached server %q: missing closing bracket", server)
+		}
+		if port != "" {
+			var hasColon bool
+			port, hasColon = strings.CutPrefix(port, ":")
+			if !hasColon {
+				return nil, fmt.Errorf("invalid memcached server %q: garbage after closing bracket", server)
+			}
+		}
+	default:
+		host, port, _ = cutLast(server, ":")
+	}
+
+	if host == "" {
+		return nil, fmt.Errorf("invalid memcached server %q: missing hostname", server)
+	}
+	if port == "" {
+		port = defaultPort
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid memcached server %q: invalid port number", server)
+	}
+	return memcacheAddr{net.JoinHostPort(host, port)}, nil
+}
+
+func cutLast(s, sep string) (before, after string, found bool) {
+	idx := strings.LastIndex(s, sep)
+	if idx < 0 {
+		return s, "", false
+	}
+	return s[:idx], s[idx+len(sep):], true
+}
+
+// isMemcacheServerFailure returns whether the given error indicates that the
+// server was unreachable or misbehaved (as opposed to an error that is a
+// regular part of the memcache protocol, like a cache miss).
+func isMemcacheServerFailure(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, memcache.ErrCacheMiss),
+		errors.Is(err, memcache.ErrCASConflict),
+		errors.Is(err, memcache.ErrNotStored),
+		errors.Is(err, memcache.ErrMalformedKey),
+		errors.Is(err, memcache.ErrNoServers):
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// scheduledRefresh is an entry in the schedule of a prewarm cycle.
+type scheduledRefresh struct {
+	Credential CredentialID
+	At         time.Time
+}
+
+// scheduleRefreshes distributes the refreshes of the given credentials evenly
+// over the first 3/4 of the cycle length, so that Keystone sees a smooth
+// request rate instead of a burst at the start of each cycle. The last quarter
+// of the cycle is left free as a buffer for slow requests.
+//
+// Each credential gets a slot of equal length (in the given order), and the
+// refresh happens at a random time within that slot. If cycleLength is zero,
+// all refreshes are scheduled at the start time.
+func scheduleRefreshes(creds []CredentialID, start time.Time, cycleLength time.Duration) []scheduledRefresh {
+	result := make([]scheduledRefresh, len(creds))
+	if len(creds) == 0 {
+		return result
+	}
+
+	slotLength := cycleLength * 3 / 4 / time.Duration(len(creds))
+	for idx, cred := range creds {
+		offset := time.Duration(idx) * slotLength
+		if slotLength > 0 {
+			offset += rand.N(slotLength) //nolint:gosec // jitter does not need to be cryptographically secure
+		}
+		result[idx] = scheduledRefresh{Credential: cred, At: start.Add(offset)}
+	}
+	return result
+}
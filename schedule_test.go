@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestScheduleRefreshes(t *testing.T) {
+	var creds []CredentialID
+	for idx := range 10 {
+		creds = append(creds, CredentialID{UserID: "uid", AccessKey: fmt.Sprintf("key%d", idx)})
+	}
+	start := time.Unix(1000000, 0)
+
+	// refreshes are spread over the first 3/4 of the cycle (i.e. 30s) in slots of 3s each
+	schedule := scheduleRefreshes(creds, start, 40*time.Second)
+	for idx, refresh := range schedule {
+		if refresh.Credential != creds[idx] {
+			t.Errorf("expected schedule entry %d to be for %q, but got %q", idx, creds[idx].String(), refresh.Credential.String())
+		}
+		slotStart := start.Add(time.Duration(idx) * 3 * time.Second)
+		slotEnd := slotStart.Add(3 * time.Second)
+		if refresh.At.Before(slotStart) || !refresh.At.Before(slotEnd) {
+			t.Errorf("expected refresh of %q to be in [%s, %s), but got %s", creds[idx].String(), slotStart, slotEnd, refresh.At)
+		}
+	}
+
+	// without a cycle length, everything happens at once
+	for _, refresh := range scheduleRefreshes(creds, start, 0) {
+		if !refresh.At.Equal(start) {
+			t.Errorf("expected refresh of %q at %s, but got %s", refresh.Credential.String(), start, refresh.At)
+		}
+	}
+}
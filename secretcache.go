@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// SecretCache remembers the secrets of EC2 credentials, so that
+// GetCredentialFromKeystone() does not need to look them up in Keystone
+// every time.
+type SecretCache struct {
+	revalidationInterval time.Duration
+	now                  func() time.Time // can be replaced in unit tests
+
+	mutex   sync.Mutex
+	entries map[CredentialID]secretCacheEntry
+}
+
+type secretCacheEntry struct {
+	Secret    string
+	FetchedAt time.Time
+}
+
+// NewSecretCache returns a SecretCache whose entries are used for up to
+// `revalidationInterval` after they were looked up in Keystone.
+func NewSecretCache(revalidationInterval time.Duration) *SecretCache {
+	return &SecretCache{
+		revalidationInterval: revalidationInterval,
+		now:                  time.Now,
+		entries:              make(map[CredentialID]secretCacheEntry),
+	}
+}
+
+// Get returns the cached secret of this credential, unless it is missing or
+// needs to be revalidated.
+func (c *SecretCache) Get(cred CredentialID) (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, exists := c.entries[cred]
+	if !exists || c.now().Sub(entry.FetchedAt) >= c.revalidationInterval {
+		return "", false
+	}
+	return entry.Secret, true
+}
+
+// Put records a secret that was just looked up in Keystone.
+func (c *SecretCache) Put(cred CredentialID, secret string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[cred] = secretCacheEntry{Secret: secret, FetchedAt: c.now()}
+}
+
+// Forget removes the cached secret of this credential (if any).
+func (c *SecretCache) Forget(cred CredentialID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, cred)
+}
@@ -0,0 +1,279 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// SwiftConfig contains the settings that are taken from Swift's own
+// configuration files (given with --swift-config).
+type SwiftConfig struct {
+	// From the [memcache] section of memcache.conf, or the section of the
+	// memcache middleware in proxy-server.conf (which takes precedence).
+	MemcacheServers []string
+	MemcacheOptions MemcacheOptions
+	// From the section of the s3token middleware in proxy-server.conf, or nil
+	// if there is no such section.
+	SecretCacheDuration *time.Duration
+}
+
+// LoadSwiftConfig reads Swift's configuration files (usually memcache.conf
+// and/or proxy-server.conf). The order of the files does not matter.
+func LoadSwiftConfig(paths []string) (SwiftConfig, error) {
+	files := make([]iniFile, len(paths))
+	for idx, path := range paths {
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			return SwiftConfig{}, err
+		}
+		files[idx], err = parseINI(buf)
+		if err != nil {
+			return SwiftConfig{}, fmt.Errorf("cannot parse %s: %w", path, err)
+		}
+	}
+	cfg, err := parseSwiftConfig(files)
+	if err != nil {
+		return SwiftConfig{}, fmt.Errorf("cannot parse Swift configuration in %s: %w", strings.Join(paths, ", "), err)
+	}
+	return cfg, nil
+}
+
+func parseSwiftConfig(files []iniFile) (SwiftConfig, error) {
+	// like Swift's memcache middleware, start with the options from
+	// memcache.conf and override them with the options from the middleware config
+	memcacheOpts := make(map[string]string)
+	for _, file := range files {
+		for key, value := range file["memcache"] {
+			memcacheOpts[key] = value
+		}
+	}
+	for _, file := range files {
+		for name, section := range file {
+			if isSwiftMiddlewareSection(name, section, "cache", "egg:swift#memcache") {
+				for key, value := range section {
+					memcacheOpts[key] = value
+				}
+			}
+		}
+	}
+
+	var (
+		cfg  SwiftConfig
+		errs []error
+	)
+	if value := memcacheOpts["memcache_servers"]; value != "" {
+		for server := range strings.SplitSeq(value, ",") {
+			server = strings.TrimSpace(server)
+			if server == "" {
+				continue
+			}
+			_, err := parseMemcacheServer(server)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("memcache_servers: %w", err))
+			}
+			cfg.MemcacheServers = append(cfg.MemcacheServers, server)
+		}
+	}
+	if isSwiftTrueValue(memcacheOpts["tls_enabled"]) {
+		// like Swift, ignore the other TLS options when TLS is not enabled
+		cfg.MemcacheOptions.TLS = MemcacheTLSConfig{
+			Enabled:  true,
+			CAFile:   memcacheOpts["tls_cafile"],
+			CertFile: memcacheOpts["tls_certfile"],
+			KeyFile:  memcacheOpts["tls_keyfile"],
+		}
+		if err := cfg.MemcacheOptions.TLS.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	var err error
+	cfg.MemcacheOptions.ConnectTimeout, err = parseSwiftSeconds(memcacheOpts["connect_timeout"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("connect_timeout: %w", err))
+	}
+	cfg.MemcacheOptions.IOTimeout, err = parseSwiftSeconds(memcacheOpts["io_timeout"])
+	if err != nil {
+		errs = append(errs, fmt.Errorf("io_timeout: %w", err))
+	}
+	if value := memcacheOpts["tries"]; value != "" {
+		cfg.MemcacheOptions.Tries, err = strconv.Atoi(value)
+		if err == nil && cfg.MemcacheOptions.Tries <= 0 {
+			err = errors.New("must be positive")
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tries: %w", err))
+		}
+	}
+
+	for _, file := range files {
+		for name, section := range file {
+			if !isSwiftMiddlewareSection(name, section, "s3token", "egg:swift#s3token") {
+				continue
+			}
+			// Swift parses this option with int(), so fractional seconds are not allowed
+			seconds, err := strconv.Atoi(cmp.Or(section["secret_cache_duration"], "0"))
+			if err == nil && seconds < 0 {
+				err = errors.New("must not be negative")
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("secret_cache_duration: %w", err))
+				continue
+			}
+			duration := time.Duration(seconds) * time.Second
+			cfg.SecretCacheDuration = &duration
+		}
+	}
+
+	return cfg, errors.Join(errs...)
+}
+
+// isSwiftMiddlewareSection checks whether this section of proxy-server.conf
+// configures the given middleware, either by its conventional section name or
+// by its "use" option.
+func isSwiftMiddlewareSection(name string, section map[string]string, conventionalName, egg string) bool {
+	return name == "filter:"+conventionalName ||
+		(strings.HasPrefix(name, "filter:") && section["use"] == egg)
+}
+
+// isSwiftTrueValue is like config_true_value() in swift/common/utils.
+func isSwiftTrueValue(value string) bool {
+	return slices.Contains([]string{"true", "1", "yes", "on", "t", "y"}, strings.ToLower(value))
+}
+
+// parseSwiftSeconds parses a timeout given in (possibly fractional) seconds.
+// Returns 0 for an empty string.
+func parseSwiftSeconds(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if seconds <= 0 || math.IsInf(seconds, 0) || math.IsNaN(seconds) {
+		return 0, fmt.Errorf("must be a positive number of seconds, but is %q", value)
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// iniFile contains the options from an INI file, grouped by section name.
+// Options from the [DEFAULT] section are included in all other sections.
+type iniFile map[string]map[string]string
+
+// parseINI parses an INI file in the dialect of Python's ConfigParser, which
+// is used for Swift's configuration files: Option names are case-insensitive,
+// "=" and ":" both separate names from values, lines starting with "#" or ";"
+// are comments, and indented lines continue the previous value. Like in
+// PasteDeploy, option names may be prefixed with "set " to override options
+// from [DEFAULT].
+func parseINI(buf []byte) (iniFile, error) {
+	result := make(iniFile)
+	var (
+		section     map[string]string
+		lastKey     string
+		sectionName string
+	)
+	for lineNo, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";"):
+			continue
+
+		case line[0] == ' ' || line[0] == '\t':
+			if lastKey == "" {
+				return nil, fmt.Errorf("line %d: unexpected continuation line", lineNo+1)
+			}
+			section[lastKey] += "\n" + trimmed
+
+		case strings.HasPrefix(trimmed, "["):
+			name, found := strings.CutSuffix(trimmed, "]")
+			if !found {
+				return nil, fmt.Errorf("line %d: missing closing bracket in section header", lineNo+1)
+			}
+			sectionName = strings.TrimPrefix(name, "[")
+			if result[sectionName] == nil {
+				result[sectionName] = make(map[string]string)
+			}
+			section = result[sectionName]
+			lastKey = ""
+
+		default:
+			if section == nil {
+				return nil, fmt.Errorf("line %d: option outside of section", lineNo+1)
+			}
+			idx := strings.IndexAny(trimmed, "=:")
+			if idx < 0 {
+				return nil, fmt.Errorf("line %d: expected option in the form \"name = value\"", lineNo+1)
+			}
+			key := strings.ToLower(strings.TrimSpace(trimmed[:idx]))
+			if sectionName != "DEFAULT" {
+				key = strings.TrimPrefix(key, "set ")
+			}
+			if key == "" {
+				return nil, fmt.Errorf("line %d: missing option name", lineNo+1)
+			}
+			section[key] = strings.TrimSpace(trimmed[idx+1:])
+			lastKey = key
+		}
+	}
+
+	defaults := result["DEFAULT"]
+	delete(result, "DEFAULT")
+	for _, section := range result {
+		for key, value := range defaults {
+			if _, exists := section[key]; !exists {
+				section[key] = value
+			}
+		}
+	}
+	return result, nil
+}
+
+// ApplySwiftConfigToFlags fills all flags that were not given on the command
+// line with the values from Swift's configuration. For the prewarm command,
+// this must be called before ApplyConfigToFlags(), so that our own config
+// file takes precedence over Swift's.
+func ApplySwiftConfigToFlags(cmd *cobra.Command, cfg SwiftConfig) {
+	isUnset := func(flagName string) bool { return !cmd.Flags().Changed(flagName) }
+
+	if len(cfg.MemcacheServers) > 0 && isUnset("servers") {
+		flagMemcacheServers = cfg.MemcacheServers
+	}
+	if cfg.MemcacheOptions.TLS.Enabled && isUnset("memcache-tls") {
+		flagMemcache.TLS.Enabled = true
+	}
+	if cfg.MemcacheOptions.TLS.CAFile != "" && isUnset("memcache-tls-ca-file") {
+		flagMemcache.TLS.CAFile = cfg.MemcacheOptions.TLS.CAFile
+	}
+	if cfg.MemcacheOptions.TLS.CertFile != "" && isUnset("memcache-tls-cert-file") {
+		flagMemcache.TLS.CertFile = cfg.MemcacheOptions.TLS.CertFile
+	}
+	if cfg.MemcacheOptions.TLS.KeyFile != "" && isUnset("memcache-tls-key-file") {
+		flagMemcache.TLS.KeyFile = cfg.MemcacheOptions.TLS.KeyFile
+	}
+	if cfg.MemcacheOptions.ConnectTimeout != 0 && isUnset("memcache-connect-timeout") {
+		flagMemcache.ConnectTimeout = cfg.MemcacheOptions.ConnectTimeout
+	}
+	if cfg.MemcacheOptions.IOTimeout != 0 && isUnset("memcache-io-timeout") {
+		flagMemcache.IOTimeout = cfg.MemcacheOptions.IOTimeout
+	}
+	if cfg.MemcacheOptions.Tries != 0 && isUnset("memcache-tries") {
+		flagMemcache.Tries = cfg.MemcacheOptions.Tries
+	}
+	if cfg.SecretCacheDuration != nil && *cfg.SecretCacheDuration > 0 && isUnset("expiry") {
+		flagExpiryTime = *cfg.SecretCacheDuration
+	}
+}
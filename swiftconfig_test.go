@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSwiftConfig(t *testing.T) {
+	dir := t.TempDir()
+	memcacheConfPath := filepath.Join(dir, "memcache.conf")
+	writeFile(t, memcacheConfPath, strings.Join([]string{
+		"[memcache]",
+		"memcache_servers = memcached-0:11211,",
+		"    memcached-1:11211",
+		"# options that are overridden in proxy-server.conf",
+		"connect_timeout = 0.5",
+		"tries = 5",
+		"io_timeout = 1.5",
+		"tls_enabled = true",
+		"tls_cafile = /etc/swift/memcache-ca.pem",
+	}, "\n"))
+	proxyConfPath := filepath.Join(dir, "proxy-server.conf")
+	writeFile(t, proxyConfPath, strings.Join([]string{
+		"[DEFAULT]",
+		"bind_port = 8080",
+		"connect_timeout = 0.25",
+		"",
+		"[pipeline:main]",
+		"pipeline = catch_errors memcache s3api s3token proxy-server",
+		"",
+		"[filter:memcache]",
+		"use = egg:swift#memcache",
+		"set connect_timeout = 0.1",
+		"Tries: 2",
+		"",
+		"[filter:s3token]",
+		"use = egg:swift#s3token",
+		"secret_cache_duration = 600",
+	}, "\n"))
+
+	cfg, err := LoadSwiftConfig([]string{proxyConfPath, memcacheConfPath})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	secretCacheDuration := 10 * time.Minute
+	expected := SwiftConfig{
+		MemcacheServers: []string{"memcached-0:11211", "memcached-1:11211"},
+		MemcacheOptions: MemcacheOptions{
+			TLS:            MemcacheTLSConfig{Enabled: true, CAFile: "/etc/swift/memcache-ca.pem"},
+			ConnectTimeout: 100 * time.Millisecond,
+			IOTimeout:      1500 * time.Millisecond,
+			Tries:          2,
+		},
+		SecretCacheDuration: &secretCacheDuration,
+	}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, cfg)
+	}
+
+	// without the s3token middleware, secret_cache_duration stays unset
+	cfg, err = LoadSwiftConfig([]string{memcacheConfPath})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if cfg.SecretCacheDuration != nil {
+		t.Errorf("expected no secret_cache_duration, but got %s", *cfg.SecretCacheDuration)
+	}
+}
+
+func TestLoadSwiftConfigErrors(t *testing.T) {
+	testCases := map[string]string{
+		"memcache_servers = foo":                                 "line 1: option outside of section",
+		"[memcache\nmemcache_servers = foo":                      "line 1: missing closing bracket",
+		"[memcache]\n  memcache_servers = foo":                   "line 2: unexpected continuation line",
+		"[memcache]\nmemcache_servers":                           `line 2: expected option in the form "name = value"`,
+		"[memcache]\nmemcache_servers = memcached-0:foo":         "memcache_servers: invalid memcached server",
+		"[memcache]\nio_timeout = 0":                             "io_timeout: must be a positive number of seconds",
+		"[memcache]\ntries = many":                               "tries: ",
+		"[memcache]\ntls_enabled = yes\ntls_certfile = cert.pem": "client certificate and private key for memcached must be given together",
+		"[filter:s3token]\nsecret_cache_duration = 1.5":          "secret_cache_duration: ",
+	}
+
+	for input, expectedMessage := range testCases {
+		path := filepath.Join(t.TempDir(), "swift.conf")
+		writeFile(t, path, input)
+		_, err := LoadSwiftConfig([]string{path})
+		if err == nil {
+			t.Errorf("expected error while parsing %q, but got none", input)
+			continue
+		}
+		if !strings.Contains(err.Error(), expectedMessage) {
+			t.Errorf("expected error while parsing %q to contain %q, but got %q", input, expectedMessage, err.Error())
+		}
+	}
+}
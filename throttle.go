@@ -0,0 +1,196 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sapcc/go-bits/logg"
+)
+
+var (
+	keystoneThrottledCallsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_keystone_throttled_calls_total",
+			Help: "Counts Keystone calls that had to wait because of the rate limit.",
+		},
+	)
+	keystoneRejectedCallsCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "swift_s3_cache_prewarm_keystone_rejected_calls_total",
+			Help: "Counts Keystone calls that were not made because the circuit breaker was open.",
+		},
+	)
+	keystoneBreakerStateGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "swift_s3_cache_prewarm_keystone_circuit_breaker_state",
+			Help: `Has value 1 for the current state of the circuit breaker for Keystone calls ("closed", "open" or "half_open"), and 0 for the other states.`,
+		},
+		[]string{"state"},
+	)
+)
+
+// RateLimiter is a token bucket that limits how many calls are made per second.
+type RateLimiter struct {
+	rate  float64 // tokens per second
+	burst float64
+	now   func() time.Time // can be replaced in unit tests
+
+	mutex      sync.Mutex
+	tokens     float64 // may be negative if calls have reserved future tokens
+	lastRefill time.Time
+}
+
+// NewRateLimiter returns a RateLimiter that allows up to `rate` calls per
+// second on average, and up to `burst` calls at once.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:       rate,
+		burst:      float64(max(burst, 1)),
+		now:        time.Now,
+		tokens:     float64(max(burst, 1)),
+		lastRefill: time.Now(),
+	}
+}
+
+// Wait blocks until the next call may be made, or until ctx expires.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mutex.Lock()
+	now := l.now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.lastRefill).Seconds()*l.rate)
+	l.lastRefill = now
+	// reserve a token (if none is available, this reserves a future token, so
+	// that waiting calls are served in order)
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.mutex.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+
+	keystoneThrottledCallsCounter.Inc()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// give back the token that we reserved
+		l.mutex.Lock()
+		l.tokens++
+		l.mutex.Unlock()
+		return ctx.Err()
+	}
+}
+
+// errCircuitOpen is returned by CircuitBreaker.Allow() while the circuit breaker is open.
+var errCircuitOpen = errors.New("not calling Keystone because of too many consecutive failures (circuit breaker is open)")
+
+type circuitState string
+
+const (
+	circuitClosed   circuitState = "closed"
+	circuitOpen     circuitState = "open"
+	circuitHalfOpen circuitState = "half_open"
+)
+
+// CircuitBreaker stops calls to Keystone after too many consecutive transient
+// failures. After a backoff period, a single trial call is allowed. If it
+// succeeds, calls are allowed again. If it fails, the backoff period is
+// doubled (up to a maximum).
+type CircuitBreaker struct {
+	threshold      int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	now            func() time.Time // can be replaced in unit tests
+
+	mutex               sync.Mutex
+	state               circuitState
+	consecutiveFailures int
+	backoff             time.Duration
+	openUntil           time.Time
+	trialInProgress     bool
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens after `threshold`
+// consecutive failures.
+func NewCircuitBreaker(threshold int, initialBackoff, maxBackoff time.Duration) *CircuitBreaker {
+	b := &CircuitBreaker{
+		threshold:      threshold,
+		initialBackoff: initialBackoff,
+		maxBackoff:     max(maxBackoff, initialBackoff),
+		now:            time.Now,
+	}
+	b.setStateLocked(circuitClosed)
+	return b
+}
+
+// Allow returns errCircuitOpen if no call may be made right now. Otherwise,
+// the caller must make the call and report its result to Record().
+func (b *CircuitBreaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == circuitOpen && !b.now().Before(b.openUntil) {
+		b.setStateLocked(circuitHalfOpen)
+	}
+	switch {
+	case b.state == circuitOpen, b.state == circuitHalfOpen && b.trialInProgress:
+		keystoneRejectedCallsCounter.Inc()
+		return errCircuitOpen
+	case b.state == circuitHalfOpen:
+		b.trialInProgress = true
+	}
+	return nil
+}
+
+// Record reports the result of a call that was allowed by Allow().
+// Only transient errors count as failures.
+func (b *CircuitBreaker) Record(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	wasTrial := b.trialInProgress
+	b.trialInProgress = false
+	switch {
+	case errors.Is(err, context.Canceled):
+		// we gave up on our own, so this does not tell us anything about Keystone
+		return
+	case classifyKeystoneError(err) != ErrTransient:
+		if b.state != circuitClosed {
+			logg.Info("Keystone is reachable again, closing circuit breaker")
+		}
+		b.consecutiveFailures = 0
+		b.backoff = 0
+		b.setStateLocked(circuitClosed)
+		return
+	}
+
+	b.consecutiveFailures++
+	if wasTrial || (b.state == circuitClosed && b.consecutiveFailures >= b.threshold) {
+		if b.backoff == 0 {
+			b.backoff = b.initialBackoff
+		} else {
+			b.backoff = min(2*b.backoff, b.maxBackoff)
+		}
+		b.openUntil = b.now().Add(b.backoff)
+		b.setStateLocked(circuitOpen)
+		logg.Error("opening circuit breaker for Keystone calls for %s after %d consecutive failures", b.backoff, b.consecutiveFailures)
+	}
+}
+
+func (b *CircuitBreaker) setStateLocked(state circuitState) {
+	b.state = state
+	for _, s := range []circuitState{circuitClosed, circuitOpen, circuitHalfOpen} {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		keystoneBreakerStateGauge.WithLabelValues(string(s)).Set(value)
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2026 SAP SE or an SAP affiliate company
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gophercloud/gophercloud/v2"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(20, 2)
+
+	// the first two calls are covered by the burst, the other three need to wait 50ms each
+	start := time.Now()
+	for range 5 {
+		err := l.Wait(t.Context())
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	duration := time.Since(start)
+	if duration < 150*time.Millisecond {
+		t.Errorf("expected 5 calls to take at least 150ms, but took %s", duration)
+	}
+
+	// when giving up on waiting, the reserved token is returned
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err := l.Wait(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected Wait() to fail with context.Canceled, but got %v", err)
+	}
+	l.mutex.Lock()
+	tokens := l.tokens
+	l.mutex.Unlock()
+	if tokens < -1 {
+		t.Errorf("expected reserved token to be returned, but %g tokens are left", tokens)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	b := NewCircuitBreaker(2, 10*time.Second, 30*time.Second)
+	b.now = func() time.Time { return now }
+
+	transientErr := gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusServiceUnavailable}
+	notFoundErr := gophercloud.ErrUnexpectedResponseCode{Actual: http.StatusNotFound}
+	call := func(result error) error {
+		t.Helper()
+		err := b.Allow()
+		if err == nil {
+			b.Record(result)
+		}
+		return err
+	}
+	expectAllowed := func(expected bool) {
+		t.Helper()
+		err := b.Allow()
+		if expected && err != nil {
+			t.Errorf("expected call to be allowed, but got: %s", err.Error())
+		}
+		if !expected && !errors.Is(err, errCircuitOpen) {
+			t.Errorf("expected call to be rejected, but got: %v", err)
+		}
+		if err == nil {
+			b.Record(nil)
+		}
+	}
+
+	// non-transient errors and non-consecutive failures do not open the circuit breaker
+	expectSuccess(t, call(transientErr))
+	expectSuccess(t, call(notFoundErr))
+	expectSuccess(t, call(transientErr))
+	expectAllowed(true)
+
+	// two consecutive transient failures open the circuit breaker for 10s
+	expectSuccess(t, call(transientErr))
+	expectSuccess(t, call(transientErr))
+	expectAllowed(false)
+	now = now.Add(9 * time.Second)
+	expectAllowed(false)
+
+	// after that, a single trial call is allowed
+	now = now.Add(1 * time.Second)
+	expectSuccess(t, b.Allow())
+	expectAllowed(false)
+
+	// if the trial call fails, the backoff doubles...
+	b.Record(transientErr)
+	now = now.Add(19 * time.Second)
+	expectAllowed(false)
+	now = now.Add(1 * time.Second)
+	expectSuccess(t, call(transientErr))
+
+	// ...up to the maximum
+	now = now.Add(30 * time.Second)
+	expectSuccess(t, call(transientErr))
+	now = now.Add(29 * time.Second)
+	expectAllowed(false)
+	now = now.Add(1 * time.Second)
+
+	// a successful trial call closes the circuit breaker
+	expectAllowed(true)
+	expectAllowed(true)
+	expectSuccess(t, call(transientErr))
+	expectAllowed(true)
+}
+
+func TestCircuitBreakerRejectsKeystoneCalls(t *testing.T) {
+	fk, identityV3 := newFakeKeystone(t)
+	cred := CredentialID{UserID: "user1", AccessKey: "access1"}
+	fk.Credentials[cred] = "secret1"
+	fk.LookupStatus = http.StatusServiceUnavailable
+	kc := &KeystoneClient{
+		IdentityV3:     identityV3,
+		CircuitBreaker: NewCircuitBreaker(1, time.Hour, time.Hour),
+	}
+
+	// after the first failure, Keystone is not called anymore (but the error is still considered transient)
+	for range 2 {
+		_, err := GetCredentialFromKeystone(t.Context(), kc, cred)
+		if !errors.Is(err, ErrTransient) {
+			t.Errorf("expected transient error, but got %v", err)
+		}
+	}
+	if fk.LookupCount != 1 {
+		t.Errorf("expected Keystone to be called once, but was called %d times", fk.LookupCount)
+	}
+}
+
+func expectSuccess(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+}